@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+	"net/http"
+	"time"
+)
+
+const (
+	// activationTokenTTL is how long an activation link stays valid.
+	activationTokenTTL = 3 * 24 * time.Hour
+	// At most activationMaxPerWindow links are emailed to one account per
+	// activationWindow, counting the one sent at registration.
+	activationMaxPerWindow = 3
+	activationWindow       = time.Hour
+)
+
+// createActivationTokenHandler emails a new activation link, for users whose
+// welcome email was lost or expired. Like the password reset endpoint it
+// gives the same response whether or not the address has an account.
+func (a *applicationDependencies) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if that email address has an account waiting for activation, you will receive an activation link"}
+
+	user, err := a.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = a.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+			}
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		sent, err := a.models.Emails.CountForUserSince(user.ID, time.Now().Add(-activationWindow), "user_welcome.tmpl", "token_activation.tmpl")
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if sent < activationMaxPerWindow {
+			token, err := a.models.Tokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+
+			a.background(func() {
+				emailData := map[string]interface{}{
+					"activationToken": token.Plaintext,
+					"userName":        user.Name,
+				}
+				err := a.sendEmail(user.ID, user.Email, a.userLocale(user.ID), "token_activation.tmpl", emailData)
+				if err != nil && !errors.Is(err, errEmailLimitReached) {
+					a.logger.Error(err.Error())
+				}
+			})
+		} else {
+			a.logger.Warn("activation email throttled", "user_id", user.ID)
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// remindUnactivatedUsers emails a fresh activation link to accounts that
+// have been waiting for activation for longer than -activation-reminder-after.
+// Each account is only reminded once.
+func (a *applicationDependencies) remindUnactivatedUsers() error {
+	purgeDays := int(a.config.activation.purgeAfter.Hours() / 24)
+
+	for {
+		users, err := a.models.Users.ClaimForActivationReminder(a.config.activation.reminderAfter, 100)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			token, err := a.models.Tokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
+			if err != nil {
+				return err
+			}
+
+			a.background(func() {
+				emailData := map[string]interface{}{
+					"activationToken": token.Plaintext,
+					"userName":        user.Name,
+					"purgeDays":       purgeDays,
+				}
+				err := a.sendEmail(user.ID, user.Email, a.userLocale(user.ID), "activation_reminder.tmpl", emailData)
+				if err != nil {
+					a.logger.Error(err.Error())
+				}
+			})
+		}
+
+		if len(users) < 100 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+)
+
+// listUsersAdminHandler lets admins search accounts by name or email.
+func (a *applicationDependencies) listUsersAdminHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query     string
+		Activated *bool
+		Locked    *bool
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Query = a.getSingleQueryParameter(qs, "q", "")
+	input.Activated = a.getOptionalBoolParameter(qs, "activated", v)
+	input.Locked = a.getOptionalBoolParameter(qs, "locked", v)
+
+	input.Filters.Page = a.getSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := a.models.Users.Search(input.Query, input.Activated, input.Locked, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserAdminHandler returns an account's details and the admin actions
+// taken on it. Admins see how many moods a user has, but never their content.
+func (a *applicationDependencies) showUserAdminHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	details, err := a.models.Users.GetAccountDetails(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	actions, err := a.models.AdminActions.GetAllForUser(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"user": details, "admin_actions": actions}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminUserActionHandler returns a handler that takes one of the
+// data.AdminAction* actions on an account and records which admin took it.
+func (a *applicationDependencies) adminUserActionHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.readIDParam(r)
+		if err != nil {
+			a.notFoundResponse(w, r)
+			return
+		}
+
+		admin := a.contextGetUser(r)
+
+		// Stop admins from shutting themselves out by mistake.
+		if admin.ID == id && (action == data.AdminActionDeactivate || action == data.AdminActionLock) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+
+		user, err := a.models.Users.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		switch action {
+		case data.AdminActionActivate, data.AdminActionDeactivate:
+			user.Activated = action == data.AdminActionActivate
+			err = a.models.Users.Update(user)
+			if errors.Is(err, data.ErrEditConflict) {
+				a.editConflictResponse(w, r)
+				return
+			}
+			// Signed tokens still claim the account is activated.
+			if err == nil && !user.Activated {
+				err = a.revokeSessions(user.ID)
+			}
+		case data.AdminActionLock:
+			err = a.models.Users.SetLocked(user.ID, true)
+			if err == nil {
+				err = a.revokeSessions(user.ID)
+			}
+		case data.AdminActionUnlock:
+			err = a.models.Users.SetLocked(user.ID, false)
+		case data.AdminActionLogout:
+			err = a.revokeSessions(user.ID)
+		case data.AdminActionPasswordReset:
+			if !user.Activated || user.Locked {
+				v := validator.New()
+				v.AddError("user", "must be activated and not locked to reset the password")
+				a.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+			err = a.sendPasswordResetEmail(user)
+		}
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = a.models.AdminActions.Insert(admin.ID, user.ID, action)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		a.recordAdminSecurityEvent(r, admin.ID, user.ID, action)
+		a.logger.Info("admin action", "admin_id", admin.ID, "user_id", user.ID, "action", action)
+
+		details, err := a.models.Users.GetAccountDetails(user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = a.writeJSON(w, http.StatusOK, envelope{"user": details}, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+	}
+}
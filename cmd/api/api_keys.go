@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func (a *applicationDependencies) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Keys expire after 90 days unless the user asks otherwise.
+	if input.ExpiresInDays == 0 {
+		input.ExpiresInDays = 90
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKeyLifetime(v, input.ExpiresInDays); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	key := &data.APIKey{
+		Name:   input.Name,
+		UserID: user.ID,
+		Scopes: input.Scopes,
+		Expiry: time.Now().Add(time.Duration(input.ExpiresInDays) * 24 * time.Hour),
+	}
+
+	if data.ValidateAPIKey(v, key); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = a.models.APIKeys.New(key.UserID, key.Name, key.Scopes, key.Expiry)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/api-keys/%d", key.ID))
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	keys, err := a.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	// Scoping the delete to the user means other people's keys look like they don't exist.
+	err = a.models.APIKeys.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
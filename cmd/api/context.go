@@ -29,4 +29,69 @@ func (app *applicationDependencies) contextGetUser(r *http.Request) *data.User {
 		panic("missing user value in request context")
 	}
 	return user
+}
+
+// scopesContextKey holds the scopes granted to a delegated credential such as
+// an API key or OAuth token. It is absent for login tokens, which have full
+// account access.
+const scopesContextKey = contextKey("scopes")
+
+// scopeGrantedContextKey is set once requireScope has approved a delegated credential.
+const scopeGrantedContextKey = contextKey("scopeGranted")
+
+// contextSetScopes marks the request as made with a delegated credential limited to scopes.
+func (app *applicationDependencies) contextSetScopes(r *http.Request, scopes []string) *http.Request {
+	ctx := context.WithValue(r.Context(), scopesContextKey, scopes)
+	return r.WithContext(ctx)
+}
+
+// contextGetScopes returns the credential's scopes, and false if the request
+// was made with a login token and is not limited by scope.
+func (app *applicationDependencies) contextGetScopes(r *http.Request) ([]string, bool) {
+	scopes, ok := r.Context().Value(scopesContextKey).([]string)
+	return scopes, ok
+}
+
+// contextSetScopeGranted records that the route's required scope was checked.
+func (app *applicationDependencies) contextSetScopeGranted(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), scopeGrantedContextKey, true)
+	return r.WithContext(ctx)
+}
+
+// contextScopeGranted reports whether requireScope approved the request.
+func (app *applicationDependencies) contextScopeGranted(r *http.Request) bool {
+	granted, _ := r.Context().Value(scopeGrantedContextKey).(bool)
+	return granted
+}
+
+// permissionsContextKey holds the permission codes carried by a signed
+// authentication token.
+const permissionsContextKey = contextKey("permissions")
+
+// contextSetPermissions records the user's permission codes so
+// requirePermission doesn't need to look them up.
+func (app *applicationDependencies) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the user's permission codes, and false if
+// the credential didn't carry them.
+func (app *applicationDependencies) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// requestIDContextKey holds the ID the requestID middleware gave the request.
+const requestIDContextKey = contextKey("requestID")
+
+func (app *applicationDependencies) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the request's ID, or "" outside the requestID middleware.
+func (app *applicationDependencies) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
 }
\ No newline at end of file
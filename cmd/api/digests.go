@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"feel-flow-api/internal/data"
+)
+
+// digestSendTime is the local time, on each user's digest day, from which
+// their weekly digest is sent.
+const digestSendTime = "09:00"
+
+// weeklyDigestData builds the template data for the user's weekly digest as
+// of now, and returns the locale it should be written in. A quote that can't
+// be fetched is left out rather than holding up the digest.
+func (a *applicationDependencies) weeklyDigestData(ctx context.Context, userID int64, userName string, now time.Time) (map[string]interface{}, *data.WeeklyDigest, string, error) {
+	settings, err := a.models.Settings.Get(userID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	digest, err := a.models.Moods.GetWeeklyDigest(userID, settings.Location(), now)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	emailData := map[string]interface{}{
+		"userName": userName,
+		"digest":   digest,
+	}
+
+	quote, err := a.quotes.GetRandomQuote(ctx)
+	if err != nil {
+		a.logger.Error("failed to fetch quote for weekly digest", "user_id", userID, "error", err.Error())
+	} else {
+		emailData["quote"] = quote
+	}
+
+	return emailData, digest, settings.Locale, nil
+}
+
+// sendDueDigests emails the weekly digest to everyone whose digest day and
+// time has come. Each delivery is recorded before the email is sent, so a
+// failed send is not retried.
+func (a *applicationDependencies) sendDueDigests() error {
+	for {
+		digests, err := a.models.Digests.ClaimDue(digestSendTime, 100)
+		if err != nil {
+			return err
+		}
+
+		for _, due := range digests {
+			a.background(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+
+				emailData, _, locale, err := a.weeklyDigestData(ctx, due.UserID, due.Name, time.Now())
+				if err != nil {
+					a.logger.Error(err.Error())
+					return
+				}
+
+				err = a.sendEmail(due.UserID, due.Email, locale, "weekly_digest.tmpl", emailData)
+				if err != nil {
+					a.logger.Error(err.Error())
+					return
+				}
+
+				err = a.models.Digests.MarkSent(due.UserID, due.Week)
+				if err != nil {
+					a.logger.Error(err.Error())
+				}
+			})
+		}
+
+		if len(digests) < 100 {
+			return nil
+		}
+	}
+}
+
+// previewDigestHandler renders the authenticated user's weekly digest as it
+// would be sent now, without sending it or recording a delivery. It works
+// whether or not the user has opted in.
+func (a *applicationDependencies) previewDigestHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	emailData, digest, locale, err := a.weeklyDigestData(r.Context(), user.ID, user.Name, time.Now())
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	email, err := a.mailer.Render(locale, "weekly_digest.tmpl", emailData)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"digest": digest, "email": email}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
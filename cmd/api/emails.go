@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+)
+
+// errEmailLimitReached is returned instead of sending an email to an address
+// that has already been sent -email-limit emails from the same template in
+// -email-limit-window, so forms such as registration can't be used to flood
+// someone's inbox.
+var errEmailLimitReached = errors.New("recipient has reached the email sending limit")
+
+// limitedEmailTemplates are the emails anyone can have sent to any address,
+// which -email-limit applies to. Each has its own count, so using up one
+// doesn't block the others, and security alerts and emails the user asked for
+// while logged in are never held back.
+var limitedEmailTemplates = map[string]bool{
+	"user_welcome.tmpl":     true,
+	"token_activation.tmpl": true,
+	"magic_link.tmpl":       true,
+	"password_reset.tmpl":   true,
+}
+
+// emailToken describes the token an email links to. Tokens are left out of
+// the email log, and resending the email issues a new one.
+type emailToken struct {
+	field string
+	scope string
+	ttl   time.Duration
+}
+
+var emailTokens = map[string]emailToken{
+	"user_welcome.tmpl":        {"activationToken", data.ScopeActivation, activationTokenTTL},
+	"token_activation.tmpl":    {"activationToken", data.ScopeActivation, activationTokenTTL},
+	"activation_reminder.tmpl": {"activationToken", data.ScopeActivation, activationTokenTTL},
+	"magic_link.tmpl":          {"magicLinkToken", data.ScopeMagicLink, magicLinkTokenTTL},
+	"password_reset.tmpl":      {"passwordResetToken", data.ScopePasswordReset, passwordResetTokenTTL},
+	"daily_reminder.tmpl":      {"unsubscribeToken", data.ScopeUnsubscribe, unsubscribeTokenTTL},
+	"data_export.tmpl":         {"exportToken", data.ScopeDataExport, exportTTL},
+}
+
+// privateEmailData are template data fields about the user's moods, which
+// are not kept in the email log either. Emails using them are rebuilt from
+// scratch when they are resent.
+var privateEmailData = []string{"digest", "quote"}
+
+// logEmail adds an email to the log as queued, unless the recipient has
+// reached their sending limit for templateFile. The secret and private parts
+// of emailData are left out of the log and returned, to be passed to
+// deliverEmail.
+func (a *applicationDependencies) logEmail(userID int64, recipient, locale, templateFile string, emailData map[string]interface{}) (*data.EmailMessage, map[string]interface{}, error) {
+	if a.config.mail.limit > 0 && limitedEmailTemplates[templateFile] {
+		sent, err := a.models.Emails.CountSince(recipient, templateFile, time.Now().Add(-a.config.mail.limitWindow))
+		if err != nil {
+			return nil, nil, err
+		}
+		if sent >= a.config.mail.limit {
+			a.logger.Warn("email not sent: recipient has reached the sending limit", "user_id", userID, "template", templateFile)
+			return nil, nil, errEmailLimitReached
+		}
+	}
+
+	// Render now so a broken template fails here rather than in a worker,
+	// and so the log has the subject and the locale actually used. Emails
+	// queued with enqueueEmail have no token yet, so use a blank one.
+	renderData := emailData
+	if token, ok := emailTokens[templateFile]; ok && emailData[token.field] == nil {
+		renderData = make(map[string]interface{}, len(emailData)+1)
+		for key, value := range emailData {
+			renderData[key] = value
+		}
+		renderData[token.field] = ""
+	}
+	msg, err := a.mailer.Render(locale, templateFile, renderData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored := make(map[string]interface{}, len(emailData))
+	secrets := map[string]interface{}{}
+	for key, value := range emailData {
+		stored[key] = value
+	}
+	if token, ok := emailTokens[templateFile]; ok && stored[token.field] != nil {
+		secrets[token.field] = stored[token.field]
+		delete(stored, token.field)
+	}
+	for _, key := range privateEmailData {
+		if value, ok := stored[key]; ok {
+			secrets[key] = value
+			delete(stored, key)
+		}
+	}
+
+	email := &data.EmailMessage{
+		UserID:    userID,
+		Recipient: recipient,
+		Template:  templateFile,
+		Locale:    msg.Locale,
+		Subject:   msg.Subject,
+		Data:      stored,
+	}
+	err = a.models.Emails.Insert(email)
+	if err != nil {
+		return nil, nil, err
+	}
+	return email, secrets, nil
+}
+
+// sendEmail logs an email and sends it straight away. Call it from
+// background(); use enqueueEmail for emails that must not be lost.
+func (a *applicationDependencies) sendEmail(userID int64, recipient, locale, templateFile string, emailData map[string]interface{}) error {
+	email, secrets, err := a.logEmail(userID, recipient, locale, templateFile, emailData)
+	if err != nil {
+		return err
+	}
+	return a.deliverEmail(email, secrets)
+}
+
+// deliverEmail renders a logged email with its secrets put back, sends it
+// and records the attempt in the log.
+func (a *applicationDependencies) deliverEmail(email *data.EmailMessage, secrets map[string]interface{}) error {
+	emailData := make(map[string]interface{}, len(email.Data)+len(secrets))
+	for key, value := range email.Data {
+		emailData[key] = value
+	}
+	for key, value := range secrets {
+		emailData[key] = value
+	}
+
+	sendErr := a.mailer.Send(email.Recipient, email.Locale, email.Template, emailData)
+
+	err := a.models.Emails.RecordAttempt(email.ID, sendErr)
+	if err != nil {
+		a.logger.Error(err.Error())
+	}
+	return sendErr
+}
+
+// resendSecrets issues the fresh tokens, and rebuilds the private data, that
+// a logged email needs to be sent, either again or from the job queue.
+func (a *applicationDependencies) resendSecrets(email *data.EmailMessage) (map[string]interface{}, error) {
+	secrets := map[string]interface{}{}
+
+	if token, ok := emailTokens[email.Template]; ok {
+		t, err := a.models.Tokens.New(email.UserID, token.ttl, token.scope)
+		if err != nil {
+			return nil, err
+		}
+		secrets[token.field] = t.Plaintext
+	}
+
+	if email.Template == "weekly_digest.tmpl" {
+		user, err := a.models.Users.Get(email.UserID)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		digestData, _, _, err := a.weeklyDigestData(ctx, user.ID, user.Name, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range privateEmailData {
+			if value, ok := digestData[key]; ok {
+				secrets[key] = value
+			}
+		}
+	}
+	return secrets, nil
+}
+
+// listEmailsAdminHandler returns a page of the outbound email log, newest
+// first, optionally for one recipient or status.
+func (a *applicationDependencies) listEmailsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	recipient := a.getSingleQueryParameter(qs, "recipient", "")
+	status := a.getSingleQueryParameter(qs, "status", "")
+	v.Check(status == "" || validator.PermittedValue(status, data.EmailStatuses...), "status", "must be queued, sent or failed")
+
+	filters := data.Filters{
+		Page:         a.getSingleIntegerParameter(qs, "page", 1, v),
+		PageSize:     a.getSingleIntegerParameter(qs, "page_size", 20, v),
+		Sort:         a.getSingleQueryParameter(qs, "sort", "-id"),
+		SortSafeList: []string{"id", "-id"},
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, metadata, err := a.models.Emails.GetAll(recipient, status, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// showEmailAdminHandler returns one logged email.
+func (a *applicationDependencies) showEmailAdminHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := a.models.Emails.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"email": email}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// resendEmailAdminHandler sends a logged email again in the background. Any
+// token it links to is issued afresh, and a weekly digest is rebuilt with
+// the current week, so emails like these are only resent if the account
+// still has the address they went to. The sending limit doesn't apply.
+func (a *applicationDependencies) resendEmailAdminHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := a.models.Emails.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The account may have been deleted since the email was sent.
+	user, err := a.models.Users.Get(email.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v := validator.New()
+			v.AddError("email", "the account this email was sent to no longer exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A fresh token or the user's moods must only go to the address the
+	// account has now, not one it has since moved away from.
+	_, hasToken := emailTokens[email.Template]
+	if (hasToken || email.Template == "weekly_digest.tmpl") && user.Email != email.Recipient {
+		v := validator.New()
+		v.AddError("email", "the account's email address has changed since this email was sent")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secrets, err := a.resendSecrets(email)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.models.Emails.Requeue(email.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	email.Status = data.EmailQueued
+
+	a.background(func() {
+		err := a.deliverEmail(email, secrets)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	err = a.writeJSON(w, http.StatusAccepted, envelope{"email": email}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
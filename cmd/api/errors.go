@@ -2,12 +2,15 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError logs the error message.
 func (a *applicationDependencies) logError(r *http.Request, err error) {
-	a.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	a.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI(), "request_id", a.contextGetRequestID(r))
 }
 
 // errorResponseJSON sends a JSON-formatted error message to the client.
@@ -81,4 +84,41 @@ func (a *applicationDependencies) authenticationRequiredResponse(w http.Response
 func (a *applicationDependencies) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
 	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies) accountLockedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been locked, please contact support"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies) exportInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your data export is already being prepared, please wait for the email"
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+func (a *applicationDependencies) missingScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	message := fmt.Sprintf("this credential does not have the %q scope required to access this resource", scope)
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := fmt.Sprintf("too many failed login attempts, please try again in %d seconds", seconds)
+	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
+}
+
+// oauthErrorResponse sends an error in the form OAuth2 clients expect from
+// the token and revocation endpoints (RFC 6749 section 5.2), rather than the
+// usual {"error": message} envelope.
+func (a *applicationDependencies) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	headers := http.Header{"Cache-Control": {"no-store"}}
+	if status == http.StatusUnauthorized {
+		headers.Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	err := a.writeJSON(w, status, envelope{"error": code, "error_description": description}, headers)
+	if err != nil {
+		a.logError(r, err)
+		w.WriteHeader(500)
+	}
 }
\ No newline at end of file
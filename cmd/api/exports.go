@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+)
+
+// exportTTL is how long a finished data export can be downloaded before it
+// is deleted.
+const exportTTL = 24 * time.Hour
+
+// createExportHandler starts building an archive of everything stored about
+// the user. It is built in the background and the user is emailed a
+// download link when it's ready.
+func (a *applicationDependencies) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	err := a.models.Exports.Start(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExportInProgress):
+			a.exportInProgressResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a.background(func() {
+		err := a.buildExport(user)
+		if err != nil {
+			a.logger.Error("failed to build data export", "user_id", user.ID, "error", err.Error())
+			err = a.models.Exports.Fail(user.ID)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		}
+	})
+
+	env := envelope{"message": "your data export is being prepared; we will email you a download link when it is ready"}
+	err = a.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// buildExport builds and stores the user's archive, then emails them a link
+// to download it.
+func (a *applicationDependencies) buildExport(user *data.User) error {
+	archive, err := a.exporter.Build(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.models.Exports.Complete(user.ID, archive, time.Now().Add(exportTTL))
+	if err != nil {
+		return err
+	}
+
+	token, err := a.models.Tokens.New(user.ID, exportTTL, data.ScopeDataExport)
+	if err != nil {
+		return err
+	}
+
+	emailData := map[string]interface{}{
+		"exportToken": token.Plaintext,
+		"userName":    user.Name,
+		"expiryHours": int(exportTTL.Hours()),
+	}
+	return a.sendEmail(user.ID, user.Email, a.userLocale(user.ID), "data_export.tmpl", emailData)
+}
+
+// downloadExportHandler sends the archive for the token in the emailed link.
+// The link can be used as often as needed until it expires.
+func (a *applicationDependencies) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.models.Users.GetForToken(data.ScopeDataExport, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired download link")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	archive, createdAt, err := a.models.Exports.GetArchive(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a.recordSecurityEvent(r, user.ID, data.EventDataExported)
+
+	filename := fmt.Sprintf("feel-flow-export-%s.zip", createdAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"feel-flow-api/internal/data"
+	"net/http"
+)
+
+// createGuestHandler creates an anonymous account so people can try the
+// journal before signing up. The response holds the guest token, which the
+// device sends as its bearer token from then on; it is never shown again.
+// Registering with the token converts the account and keeps its moods.
+func (a *applicationDependencies) createGuestHandler(w http.ResponseWriter, r *http.Request) {
+	user := data.NewGuest()
+
+	err := a.models.Users.Insert(user)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.models.Permissions.AddForUser(user.ID, data.DefaultPermissions...)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := a.models.Tokens.NewGuest(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.recordSecurityEvent(r, user.ID, data.EventGuestCreated)
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"user": user, "guest_token": token}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
     "net/url"
 	"errors"
@@ -122,6 +123,30 @@ func (a *applicationDependencies) getSingleIntegerParameter(qs url.Values, key s
 	return i
 }
 
+// getOptionalBoolParameter reads a true/false value from the query string.
+// It returns nil when the parameter is absent.
+func (a *applicationDependencies) getOptionalBoolParameter(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return nil
+	}
+	return &b
+}
+
+// clientIP returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 func (a *applicationDependencies) background(fn func()) {
 	a.wg.Add(1)
 	go func() {
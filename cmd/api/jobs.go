@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/jobs"
+)
+
+// Job kinds.
+const jobSendEmail = "send_email"
+
+// emailJob is the payload of a send_email job: the ID of the email in the
+// outbound email log. Tokens are never stored in the job; the worker issues
+// them when it sends the email.
+type emailJob struct {
+	EmailID int64 `json:"email_id"`
+}
+
+// registerJobHandlers sets up the handler for every job kind.
+func (a *applicationDependencies) registerJobHandlers() {
+	jobs.Handle(a.jobs, jobSendEmail, func(ctx context.Context, job emailJob) error {
+		email, err := a.models.Emails.Get(job.EmailID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				// The log entry has expired, so there is nothing to send.
+				return nil
+			}
+			return err
+		}
+
+		_, err = a.models.Users.Get(email.UserID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				// The account was deleted before the email went out.
+				return nil
+			}
+			return err
+		}
+
+		secrets, err := a.resendSecrets(email)
+		if err != nil {
+			return err
+		}
+		return a.deliverEmail(email, secrets)
+	})
+}
+
+// enqueueEmail logs an email and queues it to be sent by a job worker.
+// Unlike sending it with background(), the email survives a restart and is
+// retried with backoff if the SMTP server is unavailable. Leave the token
+// the template links to out of emailData: the worker issues a fresh one for
+// each attempt, so it is never written to the jobs table.
+func (a *applicationDependencies) enqueueEmail(userID int64, recipient, locale, templateFile string, emailData map[string]interface{}) error {
+	email, _, err := a.logEmail(userID, recipient, locale, templateFile, emailData)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = a.jobs.Enqueue(ctx, jobSendEmail, emailJob{EmailID: email.ID})
+	return err
+}
+
+// runJobWorkers runs the job queue's workers as a background task until the
+// server starts shutting down. Jobs already running are finished before
+// serve() returns.
+func (a *applicationDependencies) runJobWorkers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.background(func() {
+		defer cancel()
+		go func() {
+			<-a.shutdown
+			cancel()
+		}()
+		a.jobs.Run(ctx)
+	})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/jwt"
+)
+
+// Authentication token modes.
+const (
+	authModeOpaque = "opaque"
+	authModeJWT    = "jwt"
+)
+
+// authenticationTokenTTL is how long a login token stays valid in either mode.
+const authenticationTokenTTL = 24 * time.Hour
+
+// authClaims are the claims carried by a signed authentication token. They
+// hold enough of the user record, and the user's permission codes, for the
+// authenticate and requirePermission middleware to run without going to the
+// database.
+type authClaims struct {
+	jwt.RegisteredClaims
+	Name        string           `json:"name"`
+	Email       string           `json:"email"`
+	Activated   bool             `json:"activated"`
+	Permissions data.Permissions `json:"permissions,omitempty"`
+	// IssuedAtMs is iat in milliseconds, so a token issued in the same
+	// second as, but after, a revocation of all the user's tokens is kept.
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
+}
+
+// revokedBy reports whether the token was issued at or before revokedAt.
+// Tokens from before iat_ms was added are compared in whole seconds.
+func (c *authClaims) revokedBy(revokedAt time.Time) bool {
+	if c.IssuedAtMs == 0 {
+		return c.IssuedAt <= revokedAt.Unix()
+	}
+	return c.IssuedAtMs <= revokedAt.UnixMilli()
+}
+
+// newJWTKeySet builds the key set from the -jwt-* flags.
+func newJWTKeySet(settings serverConfig) (*jwt.KeySet, error) {
+	if len(settings.jwt.keys) == 0 {
+		return nil, errors.New("-jwt-keys must be set when -auth-mode=jwt")
+	}
+
+	keys := make([]*jwt.Key, 0, len(settings.jwt.keys))
+	for _, spec := range settings.jwt.keys {
+		key, err := jwt.ParseKey(settings.jwt.algorithm, spec)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	// Default to signing with the first key listed.
+	signingKeyID := settings.jwt.signingKeyID
+	if signingKeyID == "" {
+		signingKeyID = keys[0].ID
+	}
+	return jwt.NewKeySet(signingKeyID, keys...)
+}
+
+// newAuthenticationToken issues a login token for the user in whichever
+// mode the server is running.
+func (a *applicationDependencies) newAuthenticationToken(user *data.User) (*data.Token, error) {
+	if a.config.auth.mode != authModeJWT {
+		return a.models.Tokens.New(user.ID, authenticationTokenTTL, data.ScopeAuthentication)
+	}
+
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := a.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := authClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    a.config.jwt.issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			ID:        base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(jti),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(authenticationTokenTTL).Unix(),
+		},
+		Name:        user.Name,
+		Email:       user.Email,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		IssuedAtMs:  now.UnixMilli(),
+	}
+
+	signed, err := a.jwtKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    claims.Expiry(),
+		Scope:     data.ScopeAuthentication,
+	}, nil
+}
+
+// verifyJWT checks a signed token and returns the claims it carries.
+func (a *applicationDependencies) verifyJWT(token string) (*authClaims, error) {
+	var claims authClaims
+	err := a.jwtKeys.Verify(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != a.config.jwt.issuer {
+		return nil, jwt.ErrInvalidToken
+	}
+	if claims.ID == "" || a.denyList.contains(claims.ID) {
+		return nil, jwt.ErrInvalidToken
+	}
+	if revokedAt, found := a.denyList.sessionsRevokedAt(claims.Subject); found && claims.revokedBy(revokedAt) {
+		return nil, jwt.ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// userFromClaims builds the request user from the token claims alone. It
+// has no password hash or version, so handlers that write the user back to
+// the database must load a fresh copy first.
+func userFromClaims(claims *authClaims) (*data.User, error) {
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, fmt.Errorf("invalid subject claim %q", claims.Subject)
+	}
+	return &data.User{
+		ID:        id,
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: claims.Activated,
+	}, nil
+}
+
+// tokenDenyList is an in-memory copy of the token_denylist table so the
+// authenticate middleware can reject revoked tokens without a query. It is
+// refreshed from the database periodically, so a token revoked on another
+// instance is rejected here within one refresh interval.
+type tokenDenyList struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+}
+
+func newTokenDenyList() *tokenDenyList {
+	return &tokenDenyList{entries: make(map[string]time.Time)}
+}
+
+func (d *tokenDenyList) contains(jti string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, found := d.entries[jti]
+	return found
+}
+
+func (d *tokenDenyList) add(jti string, expiry time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if expiry.After(d.entries[jti]) {
+		d.entries[jti] = expiry
+	}
+}
+
+// sessionsDenyKey is the deny-list entry that revokes every token issued to
+// a user up to a point in time. Token IDs are base32, so it can't clash with
+// one. The entry expires once the last token it revokes would have, so the
+// revocation time is its expiry less authenticationTokenTTL.
+func sessionsDenyKey(subject string) string {
+	return "user:" + subject
+}
+
+// sessionsRevokedAt returns when all of the user's tokens were last revoked.
+func (d *tokenDenyList) sessionsRevokedAt(subject string) (time.Time, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	expiry, found := d.entries[sessionsDenyKey(subject)]
+	if !found {
+		return time.Time{}, false
+	}
+	return expiry.Add(-authenticationTokenTTL), true
+}
+
+// merge adds entries loaded from the database and drops expired ones.
+// Revocations are never undone, so nothing else needs removing.
+func (d *tokenDenyList) merge(denied []data.DeniedToken) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, token := range denied {
+		if token.Expiry.After(d.entries[token.ID]) {
+			d.entries[token.ID] = token.Expiry
+		}
+	}
+	for jti, expiry := range d.entries {
+		if !expiry.After(now) {
+			delete(d.entries, jti)
+		}
+	}
+}
+
+// syncDenyList reloads the deny-list from the database every interval and
+// prunes entries for tokens that have expired, until the server starts
+// shutting down.
+func (a *applicationDependencies) syncDenyList(interval time.Duration) {
+	a.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			err := a.models.DenyList.DeleteExpired()
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+
+			denied, err := a.models.DenyList.GetAllActive()
+			if err != nil {
+				a.logger.Error(err.Error())
+			} else {
+				a.denyList.merge(denied)
+			}
+
+			select {
+			case <-a.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+	"net/http"
+	"time"
+)
+
+const (
+	// magicLinkTokenTTL is how long a login link stays valid.
+	magicLinkTokenTTL = 15 * time.Minute
+	// At most magicLinkMaxPerWindow links are emailed to one account per
+	// magicLinkWindow, so the endpoint can't be used to flood an inbox.
+	magicLinkMaxPerWindow = 3
+	magicLinkWindow       = time.Hour
+)
+
+// createMagicLinkTokenHandler emails a single-use login link. Like the
+// password reset endpoint it gives the same response whether or not the
+// address has an account, and whether or not the email was throttled.
+func (a *applicationDependencies) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if that email address has an activated account, you will receive a login link"}
+
+	user, err := a.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = a.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+			}
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		sent, err := a.models.Emails.CountForUserSince(user.ID, time.Now().Add(-magicLinkWindow), "magic_link.tmpl")
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if sent < magicLinkMaxPerWindow {
+			token, err := a.models.Tokens.New(user.ID, magicLinkTokenTTL, data.ScopeMagicLink)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+
+			a.background(func() {
+				emailData := map[string]interface{}{
+					"magicLinkToken": token.Plaintext,
+					"userName":       user.Name,
+				}
+				err := a.sendEmail(user.ID, user.Email, a.userLocale(user.ID), "magic_link.tmpl", emailData)
+				if err != nil && !errors.Is(err, errEmailLimitReached) {
+					a.logger.Error(err.Error())
+				}
+			})
+		} else {
+			a.logger.Warn("magic link throttled", "user_id", user.ID)
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// exchangeMagicLinkTokenHandler logs in with the token from a login link. It
+// counts as the first login step, so users with 2FA still need their code.
+func (a *applicationDependencies) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.models.Users.GetForToken(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired login link")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Every outstanding link is used up once one of them works.
+	err = a.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.completeLogin(w, r, user)
+}
@@ -3,17 +3,29 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
 	"sync"
+	"strconv"
 	"strings"
 	"net/http"
+	// Embed the time zone database so user time zones can be validated and
+	// used on hosts without one installed.
+	_ "time/tzdata"
 
 	"feel-flow-api/internal/mailer"
 	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/jobs"
+	"feel-flow-api/internal/jwt"
+	"feel-flow-api/internal/oidc"
+	"feel-flow-api/internal/passhash"
 	"feel-flow-api/internal/quotes"
+	"feel-flow-api/internal/webauthn"
 
 	_ "github.com/lib/pq"
 )
@@ -33,18 +45,83 @@ type serverConfig struct {
 		password string
 		sender string
 	}
+	mail struct {
+		backend string
+		dir     string
+		links   mailer.Links
+		// limit is how many emails one address can be sent in limitWindow.
+		limit        int
+		limitWindow  time.Duration
+		logRetention time.Duration
+	}
 	cors struct {
 		trustedOrigins []string
 	}
+	auth struct {
+		mode string
+	}
+	jwt struct {
+		algorithm    string
+		keys         []string
+		signingKeyID string
+		issuer       string
+	}
+	login struct {
+		accountLockout data.LockoutPolicy
+		ipLockout      data.LockoutPolicy
+	}
+	passwords struct {
+		hasher     string
+		argon2id   passhash.Argon2id
+		bcryptCost int
+	}
+	totp struct {
+		key string
+	}
+	webauthn struct {
+		rpID    string
+		rpName  string
+		origins []string
+	}
+	oidc        oidc.Config
+	maintenance struct {
+		interval time.Duration
+	}
+	activation struct {
+		reminderAfter time.Duration
+		purgeAfter    time.Duration
+	}
+	deletion struct {
+		grace time.Duration
+	}
+	guests struct {
+		purgeAfter time.Duration
+	}
+	deadJobs struct {
+		retention time.Duration
+	}
+	reminders struct {
+		interval time.Duration
+	}
+	jobs jobs.Config
 }
 
 type applicationDependencies struct {
-	config serverConfig
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	quotes *quotes.Client 
-	wg     sync.WaitGroup
+	config   serverConfig
+	logger   *slog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	quotes   *quotes.Client
+	jwtKeys  *jwt.KeySet
+	denyList *tokenDenyList
+	webauthn *webauthn.RelyingParty
+	oidc     *oidc.Provider
+	exporter *data.Exporter
+	jobs     *jobs.Queue
+	wg       sync.WaitGroup
+	// shutdown is closed when the server starts shutting down, to stop
+	// long-running background tasks such as the email scheduler.
+	shutdown chan struct{}
 }
 
 type Models struct{
@@ -66,15 +143,160 @@ func main() {
 	flag.StringVar(&settings.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
 	flag.StringVar(&settings.smtp.sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "SMTP sender")
 
+	// Mail backend flags. Development and tests can keep email off the
+	// network by writing it to disk, logging it or keeping it in memory.
+	flag.StringVar(&settings.mail.backend, "mail-backend", "smtp", "How email is delivered (smtp|maildir|eml|log|memory)")
+	flag.StringVar(&settings.mail.dir, "mail-dir", "tmp/mail", "Directory the maildir and eml mail backends write to")
+	flag.StringVar(&settings.mail.links.FrontendURL, "frontend-url", mailer.DefaultLinks.FrontendURL, "Base URL of the web app that email links open")
+	flag.StringVar(&settings.mail.links.APIURL, "api-url", mailer.DefaultLinks.APIURL, "Public base URL of this API, for email links that download from it")
+	flag.IntVar(&settings.mail.limit, "email-limit", 10, "Emails one address can be sent per -email-limit-window (0 for no limit)")
+	flag.DurationVar(&settings.mail.limitWindow, "email-limit-window", time.Hour, "Window for -email-limit")
+	emailLogDays := flag.Int("email-log-retention-days", 30, "Days to keep sent emails in the outbound email log (0 keeps them)")
+
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		settings.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
 
+	// Authentication token flags. Opaque database-backed tokens are the default;
+	// -auth-mode=jwt switches to stateless signed tokens.
+	flag.StringVar(&settings.auth.mode, "auth-mode", authModeOpaque, "Authentication token mode (opaque|jwt)")
+	flag.StringVar(&settings.jwt.algorithm, "jwt-alg", jwt.HS256, "JWT signing algorithm (HS256|EdDSA)")
+	flag.Func("jwt-keys", "JWT keys as kid:base64url pairs (space separated); HS256 secrets or EdDSA seeds", func(val string) error {
+		settings.jwt.keys = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&settings.jwt.signingKeyID, "jwt-signing-key", "", "Key ID used to sign new JWTs (defaults to the first key)")
+	flag.StringVar(&settings.jwt.issuer, "jwt-issuer", "feel-flow-api", "JWT issuer claim")
+
+	// Brute-force protection for POST /v1/tokens/authentication.
+	flag.IntVar(&settings.login.accountLockout.Threshold, "login-max-failures", 5, "Failed logins for one account before it is locked")
+	flag.IntVar(&settings.login.ipLockout.Threshold, "login-ip-max-failures", 20, "Failed logins from one IP address before it is locked")
+	flag.DurationVar(&settings.login.accountLockout.BaseDelay, "login-lockout", time.Minute, "First lockout duration, doubled on each further failure")
+	flag.DurationVar(&settings.login.accountLockout.MaxDelay, "login-lockout-max", time.Hour, "Maximum lockout duration")
+	flag.DurationVar(&settings.login.accountLockout.Window, "login-failure-window", 24*time.Hour, "How long failed logins are remembered")
+
+	// Password hashing flags. Existing hashes made with other settings keep
+	// working and are upgraded when their owner next logs in.
+	settings.passwords.argon2id = passhash.DefaultArgon2id
+	flag.StringVar(&settings.passwords.hasher, "password-hasher", "argon2id", "Password hashing scheme for new hashes (argon2id|bcrypt)")
+	flag.Func("argon2-memory", "Argon2id memory cost in KiB (default 65536)", parseUint32(&settings.passwords.argon2id.Memory))
+	flag.Func("argon2-iterations", "Argon2id iterations (default 3)", parseUint32(&settings.passwords.argon2id.Iterations))
+	flag.Func("argon2-parallelism", "Argon2id parallelism (default 2)", func(val string) error {
+		p, err := strconv.ParseUint(val, 10, 8)
+		settings.passwords.argon2id.Parallelism = uint8(p)
+		return err
+	})
+	flag.IntVar(&settings.passwords.bcryptCost, "bcrypt-cost", 12, "bcrypt cost")
+
+	// TOTP secrets are encrypted at rest with this key. Without one, users
+	// can't turn on two-factor authentication.
+	flag.StringVar(&settings.totp.key, "totp-key", os.Getenv("TOTP_KEY"), "Base64url key (32 bytes) TOTP secrets are encrypted with")
+
+	// Passkey flags. The relying party ID is the domain the frontend is served
+	// from, and passkeys only work from the listed origins.
+	flag.StringVar(&settings.webauthn.rpID, "webauthn-rp-id", "localhost", "WebAuthn relying party ID (the frontend's domain)")
+	flag.StringVar(&settings.webauthn.rpName, "webauthn-rp-name", "Feel Flow", "WebAuthn relying party name shown by authenticators")
+	settings.webauthn.origins = []string{"http://localhost:3000"}
+	flag.Func("webauthn-origins", "Origins allowed to use passkeys (space separated, default http://localhost:3000)", func(val string) error {
+		settings.webauthn.origins = strings.Fields(val)
+		return nil
+	})
+
+	// OpenID Connect flags. Sign-in with an identity provider is only
+	// available when an issuer is set.
+	flag.StringVar(&settings.oidc.Issuer, "oidc-issuer", "", "OpenID Connect issuer URL (e.g. https://accounts.google.com)")
+	flag.StringVar(&settings.oidc.ClientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OpenID Connect client ID")
+	flag.StringVar(&settings.oidc.ClientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&settings.oidc.RedirectURL, "oidc-redirect-url", "http://localhost:3000/oidc/callback", "Frontend URL the identity provider redirects back to")
+	settings.oidc.Scopes = []string{"email", "profile"}
+
+	// Maintenance flags. Expired tokens are deleted every interval,
+	// accounts that are never activated get one reminder and are then
+	// deleted, guest accounts that are no longer used are deleted, and
+	// accounts whose owner deleted them are removed once their grace period
+	// is over.
+	flag.DurationVar(&settings.maintenance.interval, "maintenance-interval", time.Hour, "How often to delete expired tokens and check for unactivated accounts")
+	flag.DurationVar(&settings.activation.reminderAfter, "activation-reminder-after", 3*24*time.Hour, "Send unactivated accounts a new activation link after this long")
+	purgeDays := flag.Int("unactivated-purge-days", 7, "Delete accounts still unactivated this many days after their reminder (0 keeps them)")
+	deletionDays := flag.Int("account-deletion-days", 14, "Days a deleted account can be recovered by logging in before it is removed (0 deletes immediately)")
+	guestPurgeDays := flag.Int("guest-purge-days", 30, "Delete guest accounts that haven't been used for this many days (0 keeps them)")
+
+	// Daily check-in reminders are sent at each user's chosen local time, and
+	// weekly digests on their chosen day.
+	flag.DurationVar(&settings.reminders.interval, "reminder-interval", time.Minute, "How often to check for daily reminders and weekly digests to send (0 disables them)")
+
+	// Job queue flags. Every instance runs workers that share the jobs table.
+	settings.jobs = jobs.DefaultConfig
+	flag.IntVar(&settings.jobs.Workers, "job-workers", settings.jobs.Workers, "Background jobs run at once by this instance (0 runs none)")
+	flag.DurationVar(&settings.jobs.PollInterval, "job-poll-interval", settings.jobs.PollInterval, "How often idle job workers check for new jobs")
+	flag.IntVar(&settings.jobs.MaxAttempts, "job-max-attempts", settings.jobs.MaxAttempts, "Attempts before a failing job is moved to the dead-letter state")
+	flag.DurationVar(&settings.jobs.BaseBackoff, "job-backoff", settings.jobs.BaseBackoff, "Delay before retrying a failed job, doubled after each further failure")
+	flag.DurationVar(&settings.jobs.MaxBackoff, "job-backoff-max", settings.jobs.MaxBackoff, "Longest delay between job retries")
+	deadJobDays := flag.Int("job-dead-retention-days", 30, "Days to keep dead jobs before they are deleted (0 keeps them)")
+
 	flag.Parse()
 
+	settings.activation.purgeAfter = time.Duration(*purgeDays) * 24 * time.Hour
+	settings.deletion.grace = time.Duration(*deletionDays) * 24 * time.Hour
+	settings.guests.purgeAfter = time.Duration(*guestPurgeDays) * 24 * time.Hour
+	settings.mail.logRetention = time.Duration(*emailLogDays) * 24 * time.Hour
+	settings.deadJobs.retention = time.Duration(*deadJobDays) * 24 * time.Hour
+
+	// The per-IP lockout uses the same timings with its own, higher threshold.
+	settings.login.ipLockout.BaseDelay = settings.login.accountLockout.BaseDelay
+	settings.login.ipLockout.MaxDelay = settings.login.accountLockout.MaxDelay
+	settings.login.ipLockout.Window = settings.login.accountLockout.Window
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	hasher, err := newPasswordHasher(settings)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	data.SetPasswordHasher(hasher)
+
+	if settings.totp.key == "" {
+		logger.Warn("-totp-key (or TOTP_KEY) is not set, so two-factor authentication enrollment is disabled")
+	} else {
+		totpKey, err := parseTOTPKey(settings.totp.key)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		err = data.SetTOTPKey(totpKey)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	mailSender, err := newMailSender(settings, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	relyingParty, err := webauthn.New(webauthn.Config{
+		RPID:    settings.webauthn.rpID,
+		RPName:  settings.webauthn.rpName,
+		Origins: settings.webauthn.origins,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var oidcProvider *oidc.Provider
+	if settings.oidc.Issuer != "" {
+		oidcProvider, err = oidc.NewProvider(settings.oidc, nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
 	db, err := openDB(settings)
 	if err != nil {
 		logger.Error(err.Error())
@@ -83,12 +305,43 @@ func main() {
 	defer db.Close()
 	logger.Info("database connection pool established")
 
+	models := data.NewModels(db)
+
 	appInstance := &applicationDependencies{
-		config: settings,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(settings.smtp.host, settings.smtp.port, settings.smtp.username, settings.smtp.password, settings.smtp.sender),
-		quotes: quotes.NewClient(),
+		config:   settings,
+		logger:   logger,
+		models:   models,
+		mailer:   mailer.New(mailSender, settings.smtp.sender, settings.mail.links),
+		quotes:   quotes.NewClient(),
+		denyList: newTokenDenyList(),
+		webauthn: relyingParty,
+		oidc:     oidcProvider,
+		exporter: data.NewExporter(models),
+		jobs:     jobs.New(db, settings.jobs, logger),
+		shutdown: make(chan struct{}),
+	}
+	appInstance.registerJobHandlers()
+
+	appInstance.runMaintenance(settings.maintenance.interval)
+	if settings.jobs.Workers > 0 {
+		appInstance.runJobWorkers()
+	}
+	if settings.reminders.interval > 0 {
+		appInstance.runEmailScheduler(settings.reminders.interval)
+	}
+
+	switch settings.auth.mode {
+	case authModeOpaque:
+	case authModeJWT:
+		appInstance.jwtKeys, err = newJWTKeySet(settings)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		appInstance.syncDenyList(30 * time.Second)
+	default:
+		logger.Error("invalid -auth-mode, must be opaque or jwt", "mode", settings.auth.mode)
+		os.Exit(1)
 	}
 
 	err = appInstance.serve()
@@ -98,6 +351,66 @@ func main() {
 	}
 }
 
+// newPasswordHasher builds the password hasher from the -password-hasher,
+// -argon2-* and -bcrypt-cost flags. Both schemes can always verify, so
+// switching between them never locks anyone out.
+func newPasswordHasher(settings serverConfig) (*passhash.Hasher, error) {
+	argon2id := settings.passwords.argon2id
+	bcrypt := passhash.Bcrypt{Cost: settings.passwords.bcryptCost}
+
+	if argon2id.Memory < 8*uint32(argon2id.Parallelism) || argon2id.Iterations < 1 || argon2id.Parallelism < 1 {
+		return nil, errors.New("invalid -argon2-* parameters")
+	}
+	if bcrypt.Cost < 4 || bcrypt.Cost > 31 {
+		return nil, errors.New("-bcrypt-cost must be between 4 and 31")
+	}
+
+	switch settings.passwords.hasher {
+	case "argon2id":
+		return passhash.NewHasher(argon2id, bcrypt), nil
+	case "bcrypt":
+		return passhash.NewHasher(bcrypt, argon2id), nil
+	default:
+		return nil, fmt.Errorf("invalid -password-hasher %q, must be argon2id or bcrypt", settings.passwords.hasher)
+	}
+}
+
+// parseTOTPKey decodes the -totp-key flag.
+func parseTOTPKey(spec string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(spec, "="))
+	if err != nil || len(key) != data.TOTPKeySize {
+		return nil, fmt.Errorf("-totp-key must be %d bytes of base64url", data.TOTPKeySize)
+	}
+	return key, nil
+}
+
+// newMailSender returns the mail backend chosen with -mail-backend.
+func newMailSender(settings serverConfig, logger *slog.Logger) (mailer.Sender, error) {
+	switch settings.mail.backend {
+	case "smtp":
+		return mailer.NewSMTPSender(settings.smtp.host, settings.smtp.port, settings.smtp.username, settings.smtp.password), nil
+	case "maildir":
+		return &mailer.FileSender{Dir: settings.mail.dir, Maildir: true}, nil
+	case "eml":
+		return &mailer.FileSender{Dir: settings.mail.dir}, nil
+	case "log":
+		return &mailer.LogSender{Logger: logger}, nil
+	case "memory":
+		return &mailer.Recorder{}, nil
+	default:
+		return nil, fmt.Errorf("invalid -mail-backend %q, must be smtp, maildir, eml, log or memory", settings.mail.backend)
+	}
+}
+
+// parseUint32 returns a flag.Func parser that stores a uint32 in dst.
+func parseUint32(dst *uint32) func(string) error {
+	return func(val string) error {
+		n, err := strconv.ParseUint(val, 10, 32)
+		*dst = uint32(n)
+		return err
+	}
+}
+
 func openDB(settings serverConfig) (*sql.DB, error) {
     db, err := sql.Open("postgres", settings.db.dsn)
     if err != nil {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"feel-flow-api/internal/data"
+)
+
+// runMaintenance deletes expired credentials, handles accounts that were
+// never activated, purges inactive guests, removes accounts scheduled for
+// deletion, trims the outbound email log and deletes old dead jobs, every
+// interval, until the server starts shutting down. Every API instance runs
+// it; the queries are safe to run concurrently.
+func (a *applicationDependencies) runMaintenance(interval time.Duration) {
+	a.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			a.cleanupExpired()
+
+			err := a.remindUnactivatedUsers()
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+
+			if a.config.activation.purgeAfter > 0 {
+				deleted, err := a.models.Users.DeleteUnactivated(a.config.activation.purgeAfter)
+				if err != nil {
+					a.logger.Error(err.Error())
+				} else if deleted > 0 {
+					a.logger.Info("deleted unactivated accounts", "count", deleted)
+				}
+			}
+
+			if a.config.guests.purgeAfter > 0 {
+				deleted, err := a.models.Users.DeleteInactiveGuests(a.config.guests.purgeAfter)
+				if err != nil {
+					a.logger.Error(err.Error())
+				} else if deleted > 0 {
+					a.logger.Info("deleted inactive guest accounts", "count", deleted)
+				}
+			}
+
+			a.deleteScheduledAccounts()
+
+			if a.config.mail.logRetention > 0 {
+				deleted, err := a.models.Emails.DeleteOlderThan(a.config.mail.logRetention)
+				if err != nil {
+					a.logger.Error(err.Error())
+				} else if deleted > 0 {
+					a.logger.Info("deleted old entries from the email log", "count", deleted)
+				}
+			}
+
+			if a.config.deadJobs.retention > 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				deleted, err := a.jobs.DeleteDead(ctx, time.Now().Add(-a.config.deadJobs.retention))
+				cancel()
+				if err != nil {
+					a.logger.Error(err.Error())
+				} else if deleted > 0 {
+					a.logger.Info("deleted dead jobs", "count", deleted)
+				}
+			}
+
+			select {
+			case <-a.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// cleanupExpired deletes single-use and short-lived credentials that have
+// expired, so the tables they live in don't grow forever.
+func (a *applicationDependencies) cleanupExpired() {
+	deleted, err := a.models.Tokens.DeleteExpired()
+	if err != nil {
+		a.logger.Error(err.Error())
+	} else if deleted > 0 {
+		a.logger.Info("deleted expired tokens", "count", deleted)
+	}
+
+	for _, deleteExpired := range []func() error{
+		a.models.WebAuthnChallenges.DeleteExpired,
+		a.models.OAuthCodes.DeleteExpired,
+		a.models.OAuthTokens.DeleteExpired,
+		a.models.OIDCStates.DeleteExpired,
+		a.models.Exports.DeleteExpired,
+	} {
+		err := deleteExpired()
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	}
+}
+
+// deleteScheduledAccounts removes accounts whose grace period has ended.
+// Their moods, tokens and other rows go with them through ON DELETE CASCADE.
+func (a *applicationDependencies) deleteScheduledAccounts() {
+	ids, err := a.models.Users.DeleteScheduled()
+	if err != nil {
+		a.logger.Error(err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		err := a.models.SecurityEvents.Insert(&data.SecurityEvent{UserID: id, Event: data.EventAccountDeleted})
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	}
+	if len(ids) > 0 {
+		a.logger.Info("deleted accounts at the end of their grace period", "count", len(ids))
+	}
+}
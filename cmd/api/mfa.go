@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/totp"
+	"feel-flow-api/internal/validator"
+	"net/http"
+	"time"
+)
+
+// totpIssuer is the account label shown in authenticator apps.
+const totpIssuer = "Feel Flow"
+
+// mfaPendingTokenTTL is how long a user has to enter their code after their password.
+const mfaPendingTokenTTL = 5 * time.Minute
+
+// secondFactor holds the code a user supplies to prove they have their
+// authenticator app, or one of their recovery codes if they don't.
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func validateSecondFactor(v *validator.Validator, f secondFactor) {
+	switch {
+	case f.Code != "" && f.RecoveryCode != "":
+		v.AddError("code", "provide either a code or a recovery code, not both")
+	case f.RecoveryCode != "":
+		data.ValidateRecoveryCode(v, f.RecoveryCode)
+	default:
+		data.ValidateTOTPCode(v, f.Code)
+	}
+}
+
+// checkSecondFactor verifies a TOTP code or consumes a recovery code for the
+// user. A TOTP code is only accepted once.
+func (a *applicationDependencies) checkSecondFactor(userID int64, f secondFactor) (bool, error) {
+	if f.RecoveryCode != "" {
+		err := a.models.RecoveryCodes.Use(userID, f.RecoveryCode)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	cred, err := a.models.TOTP.GetForUser(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return a.useTOTPCode(cred, f.Code)
+}
+
+// useTOTPCode checks a code against the credential and records its time step.
+func (a *applicationDependencies) useTOTPCode(cred *data.TOTPCredential, code string) (bool, error) {
+	step, ok := totp.DefaultOptions.Validate(cred.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err := a.models.TOTP.UseStep(cred.UserID, step)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// createTOTPHandler starts enrollment by generating a secret for the user's
+// authenticator app. 2FA is not enabled until the first code is confirmed.
+// Enrollment is turned off when no -totp-key is set to encrypt secrets with.
+func (a *applicationDependencies) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if a.config.totp.key == "" {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.models.TOTP.InsertPending(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"totp": map[string]string{
+			"secret":      totp.EncodeSecret(secret),
+			"otpauth_uri": totp.DefaultOptions.URI(totpIssuer, user.Email, secret),
+		},
+	}
+	err = a.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler enables 2FA once the user enters a valid code, and
+// returns their recovery codes.
+func (a *applicationDependencies) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if a.config.totp.key == "" {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	cred, err := a.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication enrollment has not been started")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if cred.Confirmed {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := a.useTOTPCode(cred, input.Code)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.models.TOTP.Confirm(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := a.models.RecoveryCodes.Replace(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler turns off 2FA. A valid code or recovery code is required
+// so a stolen login token alone can't remove the second factor.
+func (a *applicationDependencies) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input secondFactor
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateSecondFactor(v, input); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	enabled, err := a.models.TOTP.Enabled(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !enabled {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	ok, err := a.checkSecondFactor(user.ID, input)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.models.TOTP.Delete(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRecoveryCodesHandler replaces the user's recovery codes with a new set.
+func (a *applicationDependencies) createRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	cred, err := a.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !cred.Confirmed {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	ok, err := a.useTOTPCode(cred, input.Code)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid code")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := a.models.RecoveryCodes.Replace(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler is the second login step: it exchanges
+// the mfa-pending token from createAuthenticationTokenHandler and a code for
+// a normal authentication token.
+func (a *applicationDependencies) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactor
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	validateSecondFactor(v, input.secondFactor)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.models.Users.GetForToken(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords.
+	retryAfter, err := a.models.LoginThrottles.LockedFor(data.AccountThrottleKey(user.Email), data.IPThrottleKey(clientIP(r)))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		a.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
+	ok, err := a.checkSecondFactor(user.ID, input.secondFactor)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		a.failedLoginResponse(w, r, user.Email, user)
+		return
+	}
+
+	err = a.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.issueAuthenticationToken(w, r, user)
+}
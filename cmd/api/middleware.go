@@ -1,15 +1,19 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
 	"feel-flow-api/internal/data"
 	"feel-flow-api/internal/validator"
 	"net"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"golang.org/x/time/rate"
 )
 
@@ -26,6 +30,25 @@ func (a *applicationDependencies) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestIDPattern limits the request IDs accepted from clients and proxies,
+// since they end up in logs and the security event trail.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID gives every request an ID, reusing the X-Request-ID header sent
+// by a proxy or client when there is a valid one, and echoes it back in the
+// response so a report can be matched to the logs.
+func (a *applicationDependencies) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = rand.Text()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = a.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // rateLimit is middleware for IP-based rate limiting.
 func (app *applicationDependencies) rateLimit(next http.Handler) http.Handler {
 	type client struct {
@@ -84,18 +107,103 @@ func (app *applicationDependencies) rateLimitExceededResponse(w http.ResponseWri
 func (a *applicationDependencies) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
-		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
+		if r.Header.Get("Authorization") == "" {
 			r = a.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		token, ok := bearerToken(r)
+		if !ok {
 			a.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		token := headerParts[1]
+
+		// Personal API keys are always looked up in the database, whichever
+		// mode login tokens are issued in.
+		if strings.HasPrefix(token, data.APIKeyPrefix) {
+			v := validator.New()
+			if data.ValidateAPIKeyPlaintext(v, token); !v.IsEmpty() {
+				a.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			key, user, err := a.models.APIKeys.GetForKey(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					a.invalidAuthenticationTokenResponse(w, r)
+				default:
+					a.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			r = a.contextSetUser(r, user)
+			r = a.contextSetScopes(r, key.Scopes)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Access tokens issued to third-party apps through OAuth are scoped
+		// the same way as API keys.
+		if strings.HasPrefix(token, data.OAuthAccessTokenPrefix) {
+			oauthToken, user, err := a.models.OAuthTokens.GetForAccessToken(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					a.invalidAuthenticationTokenResponse(w, r)
+				default:
+					a.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			r = a.contextSetUser(r, user)
+			r = a.contextSetScopes(r, oauthToken.Scopes)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Guest accounts have a long-lived device token instead of logging in,
+		// and it is looked up in the database in either mode.
+		if strings.HasPrefix(token, data.GuestTokenPrefix) {
+			v := validator.New()
+			if data.ValidateGuestTokenPlaintext(v, token); !v.IsEmpty() {
+				a.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user, err := a.models.Users.GetForGuestToken(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					a.invalidAuthenticationTokenResponse(w, r)
+				default:
+					a.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			r = a.contextSetUser(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Signed tokens carry the user in their claims, so no query is needed.
+		if a.config.auth.mode == authModeJWT {
+			claims, err := a.verifyJWT(token)
+			if err != nil {
+				a.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user, err := userFromClaims(claims)
+			if err != nil {
+				a.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			r = a.contextSetUser(r, user)
+			if claims.Permissions != nil {
+				r = a.contextSetPermissions(r, claims.Permissions)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, token); !v.IsEmpty() {
 			a.invalidAuthenticationTokenResponse(w, r)
@@ -116,6 +224,15 @@ func (a *applicationDependencies) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// bearerToken returns the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", false
+	}
+	return headerParts[1], true
+}
+
 // --- NEW AUTHORIZATION MIDDLEWARE ---
 
 // requireAuthenticatedUser checks if a user is authenticated (not anonymous).
@@ -126,6 +243,12 @@ func (a *applicationDependencies) requireAuthenticatedUser(next http.HandlerFunc
 			a.authenticationRequiredResponse(w, r)
 			return
 		}
+		// API keys and OAuth tokens may only be used on routes that declare a
+		// scope with requireScope.
+		if _, delegated := a.contextGetScopes(r); delegated && !a.contextScopeGranted(r) {
+			a.notPermittedResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	}
 }
@@ -144,6 +267,77 @@ func (a *applicationDependencies) requireActivatedUser(next http.HandlerFunc) ht
 	return a.requireAuthenticatedUser(fn)
 }
 
+// requirePermission checks that the user's account has been granted the
+// permission code. It also checks that the account is activated, or is a
+// guest account, which has no email address to activate, so routes that use
+// it don't need requireActivatedUser. Signed tokens carry the permission
+// codes, so they are only looked up for other credentials, and for admin
+// permissions, which must stop working as soon as they are taken away.
+func (a *applicationDependencies) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if !user.Activated && !user.Guest {
+			a.inactiveAccountResponse(w, r)
+			return
+		}
+
+		permissions, ok := a.contextGetPermissions(r)
+		if !ok || strings.HasPrefix(code, "admin:") {
+			var err error
+			permissions, err = a.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if !permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireAuthenticatedUser(fn)
+}
+
+// requireSelf serves routes under /v1/users/:id for the authenticated user
+// only. The id is "me", or the user's own ID for clients written before the
+// /v1/users/me routes were added; any other ID is refused as before.
+func (a *applicationDependencies) requireSelf(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") != "me" {
+			id, err := a.readIDParam(r)
+			if err != nil {
+				a.notFoundResponse(w, r)
+				return
+			}
+			if id != a.contextGetUser(r).ID {
+				a.notPermittedResponse(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireScope checks that a delegated credential, such as an API key or
+// OAuth token, was granted scope. Login tokens are not limited by scope. It
+// must wrap requireActivatedUser or requirePermission, which turn away
+// delegated credentials on routes without a scope.
+func (a *applicationDependencies) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes, delegated := a.contextGetScopes(r)
+		if delegated {
+			if !slices.Contains(scopes, scope) {
+				a.missingScopeResponse(w, r, scope)
+				return
+			}
+			r = a.contextSetScopeGranted(r)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func (a *applicationDependencies) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set the header to allow ANY origin (*)
@@ -153,7 +347,8 @@ func (a *applicationDependencies) enableCORS(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
 
 		// Allow specific headers (Authorization is crucial for tokens)
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 		// Handle "Preflight" requests (Browser asks "Can I talk to you?")
 		if r.Method == http.MethodOptions {
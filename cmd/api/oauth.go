@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// createOAuthClientHandler registers a third-party app. The client secret
+// of a confidential client is only returned here.
+func (a *applicationDependencies) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	client := &data.OAuthClient{
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Confidential: input.Confidential,
+		UserID:       a.contextGetUser(r).ID,
+	}
+
+	v := validator.New()
+	if data.ValidateOAuthClient(v, client); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.models.OAuthClients.New(client)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := a.models.OAuthClients.GetAllForUser(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := httprouter.ParamsFromContext(r.Context()).ByName("client_id")
+
+	err := a.models.OAuthClients.Delete(clientID, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// authorizationRequest holds the parameters of an OAuth2 authorization
+// request. The frontend forwards them from the app's redirect to
+// GET /v1/oauth/authorize to show the consent screen, then to
+// POST /v1/oauth/authorize with the user's decision.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// checkAuthorizationRequest validates req and returns its client and scopes.
+// If the redirect URI is missing and the client only has one, it is filled in.
+func (a *applicationDependencies) checkAuthorizationRequest(v *validator.Validator, req *authorizationRequest) (*data.OAuthClient, []string, error) {
+	client, err := a.models.OAuthClients.Get(req.ClientID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("client_id", "unknown client")
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	v.Check(client.HasRedirectURI(req.RedirectURI), "redirect_uri", "must exactly match one of the client's redirect URIs")
+	v.Check(req.ResponseType == "code", "response_type", "must be code")
+
+	scopes := data.ParseOAuthScope(req.Scope)
+	data.ValidateOAuthScopes(v, scopes)
+	data.ValidatePKCEChallenge(v, req.CodeChallenge, req.CodeChallengeMethod)
+	return client, scopes, nil
+}
+
+// showAuthorizationHandler describes an authorization request so the
+// frontend can ask the user for consent.
+func (a *applicationDependencies) showAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	req := authorizationRequest{
+		ResponseType:        qs.Get("response_type"),
+		ClientID:            qs.Get("client_id"),
+		RedirectURI:         qs.Get("redirect_uri"),
+		Scope:               qs.Get("scope"),
+		State:               qs.Get("state"),
+		CodeChallenge:       qs.Get("code_challenge"),
+		CodeChallengeMethod: qs.Get("code_challenge_method"),
+	}
+
+	v := validator.New()
+	client, scopes, err := a.checkAuthorizationRequest(v, &req)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{
+		"client": map[string]string{"client_id": client.ID, "name": client.Name},
+		"scopes": scopes,
+	}
+	err = a.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// authorizeHandler records the user's decision and returns the URL to send
+// the browser back to the app with, carrying either a code or an error.
+func (a *applicationDependencies) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		authorizationRequest
+		Approve bool `json:"approve"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	client, scopes, err := a.checkAuthorizationRequest(v, &input.authorizationRequest)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	redirect, err := url.Parse(input.RedirectURI)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	params := redirect.Query()
+	if input.State != "" {
+		params.Set("state", input.State)
+	}
+
+	if input.Approve {
+		user := a.contextGetUser(r)
+
+		err = a.models.OAuthGrants.Upsert(user.ID, client.ID, scopes)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		code := &data.OAuthCode{
+			ClientID:      client.ID,
+			UserID:        user.ID,
+			RedirectURI:   input.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: input.CodeChallenge,
+		}
+		err = a.models.OAuthCodes.Insert(code)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		params.Set("code", code.Plaintext)
+	} else {
+		params.Set("error", "access_denied")
+		params.Set("error_description", "the user denied the request")
+	}
+	redirect.RawQuery = params.Encode()
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"redirect_to": redirect.String()}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// authenticateOAuthClient identifies the client calling the token or
+// revocation endpoint, from HTTP Basic auth or the client_id and
+// client_secret form fields. Confidential clients must send their secret.
+func (a *applicationDependencies) authenticateOAuthClient(r *http.Request) (*data.OAuthClient, bool, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes the credentials before Basic encoding.
+		var err1, err2 error
+		clientID, err1 = url.QueryUnescape(clientID)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return nil, false, nil
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := a.models.OAuthClients.Get(clientID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if client.Confidential && !client.SecretMatches(secret) {
+		return nil, false, nil
+	}
+	return client, true, nil
+}
+
+// parseOAuthForm reads the form-encoded body the token and revocation endpoints take.
+func parseOAuthForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	return r.ParseForm()
+}
+
+// oauthTokenHandler is the OAuth2 token endpoint. It exchanges an
+// authorization code (with its PKCE verifier) or a refresh token for a new
+// access token and refresh token. Refresh tokens are single use.
+func (a *applicationDependencies) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := parseOAuthForm(w, r)
+	if err != nil {
+		a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body must be form-encoded")
+		return
+	}
+
+	client, ok, err := a.authenticateOAuthClient(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		a.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	var userID int64
+	var scopes []string
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := a.models.OAuthCodes.Consume(r.PostForm.Get("code"), client.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid, expired or already used")
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if r.PostForm.Get("redirect_uri") != code.RedirectURI {
+			a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+			return
+		}
+		if !data.PKCEMatches(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code challenge")
+			return
+		}
+		userID, scopes = code.UserID, code.Scopes
+
+	case "refresh_token":
+		token, err := a.models.OAuthTokens.ConsumeRefreshToken(r.PostForm.Get("refresh_token"), client.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid, expired or already used")
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		userID, scopes = token.UserID, token.Scopes
+
+		// A client may ask for fewer scopes than it was granted, never more.
+		if requested := data.ParseOAuthScope(r.PostForm.Get("scope")); len(requested) > 0 {
+			for _, scope := range requested {
+				if !slices.Contains(scopes, scope) {
+					a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "the requested scope exceeds the original grant")
+					return
+				}
+			}
+			scopes = requested
+		}
+
+	default:
+		a.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	pair, err := a.models.OAuthTokens.NewPair(client.ID, userID, scopes)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"access_token":  pair.AccessToken,
+		"token_type":    pair.TokenType,
+		"expires_in":    pair.ExpiresIn,
+		"refresh_token": pair.RefreshToken,
+		"scope":         pair.Scope,
+	}
+	err = a.writeJSON(w, http.StatusOK, env, http.Header{"Cache-Control": {"no-store"}})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// oauthRevokeHandler revokes an access or refresh token (RFC 7009). It
+// succeeds even for unknown tokens so clients can always clean up.
+func (a *applicationDependencies) oauthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	err := parseOAuthForm(w, r)
+	if err != nil {
+		a.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body must be form-encoded")
+		return
+	}
+
+	client, ok, err := a.authenticateOAuthClient(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		a.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	err = a.models.OAuthTokens.Revoke(r.PostForm.Get("token"), client.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "token revoked"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOAuthGrantsHandler lists the apps the user has given access to.
+func (a *applicationDependencies) listOAuthGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	grants, err := a.models.OAuthGrants.GetAllForUser(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"grants": grants}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOAuthGrantHandler removes an app's access and revokes its tokens.
+func (a *applicationDependencies) deleteOAuthGrantHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := httprouter.ParamsFromContext(r.Context()).ByName("client_id")
+
+	err := a.models.OAuthGrants.Delete(a.contextGetUser(r).ID, clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "access successfully revoked"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/oidc"
+	"feel-flow-api/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// createOIDCAuthorizationHandler starts a sign-in with the identity provider
+// and returns the URL to send the browser to.
+func (a *applicationDependencies) createOIDCAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	state, err := oidc.NewState()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.models.OIDCStates.Insert(state, nonce)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	redirect, err := a.oidc.AuthCodeURL(r.Context(), state, nonce)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"redirect_to": redirect}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// oidcCallbackHandler finishes a sign-in with the code and state the
+// identity provider redirected the browser back to the frontend with.
+func (a *applicationDependencies) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	nonce, err := a.models.OIDCStates.Consume(input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "sign-in has expired or was already used; please try again")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := a.oidc.Exchange(r.Context(), input.Code, nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchange),
+			errors.Is(err, oidc.ErrInvalidToken),
+			errors.Is(err, oidc.ErrUnknownKey),
+			errors.Is(err, oidc.ErrExpired),
+			errors.Is(err, oidc.ErrNonceMismatch):
+			a.logger.Warn("identity provider sign-in rejected", "error", err.Error())
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := a.models.Identities.GetUser(a.oidc.Issuer(), claims.Subject)
+	switch {
+	case err == nil:
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = a.linkIdentity(claims)
+		if err != nil {
+			if errors.Is(err, errUnverifiedIdentityEmail) {
+				v.AddError("email", err.Error())
+				a.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	default:
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.completeLogin(w, r, user)
+}
+
+var errUnverifiedIdentityEmail = errors.New("the identity provider has not verified this account's email address")
+
+// linkIdentity links a first-time external identity to the account with the
+// same email address, creating the account if there isn't one. Only an
+// address the provider has verified is trusted, otherwise anyone could take
+// over an account by signing up at a provider with its address.
+func (a *applicationDependencies) linkIdentity(claims *oidc.Claims) (*data.User, error) {
+	v := validator.New()
+	if data.ValidateEmail(v, claims.Email); !claims.EmailVerified || !v.IsEmpty() {
+		return nil, errUnverifiedIdentityEmail
+	}
+
+	user, err := a.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Signing in proves the user owns the address, the same as following
+		// the activation link would. But an unactivated account may have
+		// been registered by someone else before the owner ever signed up,
+		// so the password and anything else they set up is thrown away
+		// rather than handed over with the activated account.
+		if !user.Activated {
+			user.Activated = true
+			err = user.Password.Set(rand.Text() + rand.Text())
+			if err != nil {
+				return nil, err
+			}
+			err = a.models.Users.Update(user)
+			if err != nil {
+				return nil, err
+			}
+			err = a.models.Users.DeleteCredentials(user.ID)
+			if err != nil {
+				return nil, err
+			}
+			err = a.revokeSessions(user.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user = &data.User{
+			Name:      identityName(claims),
+			Email:     claims.Email,
+			Activated: true,
+		}
+		// The account has no usable password until the user resets it.
+		err = user.Password.Set(rand.Text() + rand.Text())
+		if err != nil {
+			return nil, err
+		}
+		err = a.models.Users.Insert(user)
+		if err != nil {
+			return nil, err
+		}
+		err = a.models.Permissions.AddForUser(user.ID, data.DefaultPermissions...)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = a.models.Identities.Insert(&data.Identity{
+		UserID:  user.ID,
+		Issuer:  a.oidc.Issuer(),
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// identityName picks a display name for a new account, falling back to the
+// local part of the email address.
+func identityName(claims *oidc.Claims) string {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	return name
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+)
+
+const (
+	// reminderWindow is how late a reminder can still be sent, for when the
+	// scheduler was not running at the user's reminder time.
+	reminderWindow = time.Hour
+	// unsubscribeTokenTTL is how long the unsubscribe link in a reminder
+	// works.
+	unsubscribeTokenTTL = 30 * 24 * time.Hour
+)
+
+// sendDueReminders emails everyone whose reminder time has come and who
+// hasn't logged a mood today. Each user is claimed before their email is
+// sent, so a failed send is not retried until the next day.
+func (a *applicationDependencies) sendDueReminders() error {
+	for {
+		reminders, err := a.models.Reminders.ClaimDue(reminderWindow, 100)
+		if err != nil {
+			return err
+		}
+
+		for _, reminder := range reminders {
+			token, err := a.models.Tokens.New(reminder.UserID, unsubscribeTokenTTL, data.ScopeUnsubscribe)
+			if err != nil {
+				return err
+			}
+
+			a.background(func() {
+				emailData := map[string]interface{}{
+					"userName":         reminder.Name,
+					"unsubscribeToken": token.Plaintext,
+				}
+				err := a.sendEmail(reminder.UserID, reminder.Email, reminder.Locale, "daily_reminder.tmpl", emailData)
+				if err != nil {
+					a.logger.Error(err.Error())
+				}
+			})
+		}
+
+		if len(reminders) < 100 {
+			return nil
+		}
+	}
+}
+
+// unsubscribeRemindersHandler turns off daily reminders from the link in a
+// reminder email, without signing in. The link keeps working, so following
+// it twice is harmless.
+func (a *applicationDependencies) unsubscribeRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.models.Users.GetForToken(data.ScopeUnsubscribe, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unsubscribe token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.models.Reminders.Unsubscribe(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "you will no longer receive daily reminders"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"net/http"
+
+	"feel-flow-api/internal/data"
+
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -20,19 +23,86 @@ func (a *applicationDependencies) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/api/v1/quote", a.getQuoteHandler)
 
 	// Mood routes (ALL PROTECTED)
-	router.HandlerFunc(http.MethodGet, "/v1/moods", a.requireActivatedUser(a.listMoodsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/moods", a.requireActivatedUser(a.createMoodHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/moods/:id", a.requireActivatedUser(a.showMoodHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/moods/:id", a.requireActivatedUser(a.updateMoodHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/moods/:id", a.requireActivatedUser(a.deleteMoodHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/moods", a.requireActivatedUser(a.deleteAllMoodsHandler))
+	// requireScope lets API keys with the matching scope use these routes too.
+	// The account itself also needs the matching permission.
+	router.HandlerFunc(http.MethodGet, "/v1/moods", a.requireScope(data.ScopeMoodsRead, a.requirePermission(data.PermissionMoodsRead, a.listMoodsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/moods", a.requireScope(data.ScopeMoodsWrite, a.requirePermission(data.PermissionMoodsWrite, a.createMoodHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/moods/:id", a.requireScope(data.ScopeMoodsRead, a.requirePermission(data.PermissionMoodsRead, a.showMoodHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/moods/:id", a.requireScope(data.ScopeMoodsWrite, a.requirePermission(data.PermissionMoodsWrite, a.updateMoodHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/moods/:id", a.requireScope(data.ScopeMoodsWrite, a.requirePermission(data.PermissionMoodsWrite, a.deleteMoodHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/moods", a.requireScope(data.ScopeMoodsWrite, a.requirePermission(data.PermissionMoodsWrite, a.deleteAllMoodsHandler)))
+
+	// API key routes. These can only be reached with a login token, never with an API key.
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", a.requireActivatedUser(a.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", a.requireActivatedUser(a.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", a.requireActivatedUser(a.deleteAPIKeyHandler))
+
+	// Two-factor authentication routes
+	router.HandlerFunc(http.MethodPost, "/v1/mfa/totp", a.requireActivatedUser(a.createTOTPHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/mfa/totp", a.requireActivatedUser(a.confirmTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/mfa/totp", a.requireActivatedUser(a.deleteTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/mfa/recovery-codes", a.requireActivatedUser(a.createRecoveryCodesHandler))
+
+	// Passkey routes
+	router.HandlerFunc(http.MethodPost, "/v1/webauthn/register/begin", a.requireActivatedUser(a.beginPasskeyRegistrationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webauthn/register/finish", a.requireActivatedUser(a.finishPasskeyRegistrationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webauthn/login/begin", a.beginPasskeyLoginHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/webauthn/login/finish", a.finishPasskeyLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/webauthn/credentials", a.requireActivatedUser(a.listPasskeysHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webauthn/credentials/:id", a.requireActivatedUser(a.deletePasskeyHandler))
+
+	// OAuth routes. Apps authenticate to the token and revoke endpoints with
+	// their client credentials rather than a bearer token.
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/clients", a.requireActivatedUser(a.listOAuthClientsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/clients", a.requireActivatedUser(a.createOAuthClientHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/oauth/clients/:client_id", a.requireActivatedUser(a.deleteOAuthClientHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/authorize", a.requireActivatedUser(a.showAuthorizationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/authorize", a.requireActivatedUser(a.authorizeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/token", a.oauthTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/revoke", a.oauthRevokeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/grants", a.requireActivatedUser(a.listOAuthGrantsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/oauth/grants/:client_id", a.requireActivatedUser(a.deleteOAuthGrantHandler))
+
+	// Admin routes. Only accounts granted the admin:users permission can use them.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", a.requirePermission(data.PermissionAdminUsers, a.listUsersAdminHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", a.requirePermission(data.PermissionAdminUsers, a.showUserAdminHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/activate", a.requirePermission(data.PermissionAdminUsers, a.adminUserActionHandler(data.AdminActionActivate)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/deactivate", a.requirePermission(data.PermissionAdminUsers, a.adminUserActionHandler(data.AdminActionDeactivate)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/lock", a.requirePermission(data.PermissionAdminUsers, a.adminUserActionHandler(data.AdminActionLock)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/unlock", a.requirePermission(data.PermissionAdminUsers, a.adminUserActionHandler(data.AdminActionUnlock)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/logout", a.requirePermission(data.PermissionAdminUsers, a.adminUserActionHandler(data.AdminActionLogout)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", a.requirePermission(data.PermissionAdminUsers, a.adminUserActionHandler(data.AdminActionPasswordReset)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/security-events", a.requirePermission(data.PermissionAdminUsers, a.listSecurityEventsAdminHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails", a.requirePermission(data.PermissionAdminUsers, a.listEmailsAdminHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails/:id", a.requirePermission(data.PermissionAdminUsers, a.showEmailAdminHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/emails/:id/resend", a.requirePermission(data.PermissionAdminUsers, a.resendEmailAdminHandler))
 
 	// User routes (some public, some protected)
 	router.HandlerFunc(http.MethodPost, "/v1/users", a.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/guest", a.createGuestHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", a.activateUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", a.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler) // Add this route
-	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", a.requireActivatedUser(a.updateUserHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", a.requireActivatedUser(a.deleteUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", a.requireAuthenticatedUser(a.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", a.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", a.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", a.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/tokens/magic-link", a.exchangeMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/authorize", a.createOIDCAuthorizationHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/callback", a.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", a.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/security-events", a.requireActivatedUser(a.listSecurityEventsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/export", a.requireActivatedUser(a.createExportHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/settings", a.requireActivatedUser(a.showUserSettingsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id/settings", a.requireActivatedUser(a.requireSelf(a.updateUserSettingsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/digest/preview", a.requireActivatedUser(a.previewDigestHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exports/download", a.downloadExportHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/reminders/unsubscribe", a.unsubscribeRemindersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", a.requireAuthenticatedUser(a.showCurrentUserHandler))
+	// httprouter can't mix /v1/users/me with /v1/users/:id for one method, so
+	// these take "me" or, for older clients, the user's own ID.
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", a.requireActivatedUser(a.requireSelf(a.updateUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", a.requireActivatedUser(a.requireSelf(a.deleteUserHandler)))
 	
-	return a.recoverPanic(a.enableCORS(a.rateLimit(a.authenticate(router))))
+	return a.requestID(a.recoverPanic(a.enableCORS(a.rateLimit(a.authenticate(router)))))
 }
\ No newline at end of file
@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// runEmailScheduler sends the daily reminders and weekly digests that are
+// due every interval, until the server starts shutting down. It runs as a
+// background task, so serve() waits for it and the emails it started before
+// exiting.
+func (a *applicationDependencies) runEmailScheduler(interval time.Duration) {
+	a.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			for _, send := range []func() error{a.sendDueReminders, a.sendDueDigests} {
+				err := send()
+				if err != nil {
+					a.logger.Error(err.Error())
+				}
+			}
+
+			select {
+			case <-a.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+)
+
+// maxUserAgentLength caps how much of the User-Agent header is stored.
+const maxUserAgentLength = 512
+
+// recordSecurityEvent adds an event to the user's audit trail, along with
+// where the request came from. A failure is logged rather than returned: the
+// action being recorded has already happened.
+func (a *applicationDependencies) recordSecurityEvent(r *http.Request, userID int64, event string) {
+	a.insertSecurityEvent(r, &data.SecurityEvent{UserID: userID, Event: event})
+}
+
+// recordAdminSecurityEvent is recordSecurityEvent for an action an admin
+// took on the user's account.
+func (a *applicationDependencies) recordAdminSecurityEvent(r *http.Request, adminID, userID int64, action string) {
+	a.insertSecurityEvent(r, &data.SecurityEvent{UserID: userID, ActorID: &adminID, Event: data.AdminActionEvent(action)})
+}
+
+func (a *applicationDependencies) insertSecurityEvent(r *http.Request, event *data.SecurityEvent) {
+	event.IPAddress = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+	event.RequestID = a.contextGetRequestID(r)
+
+	err := a.models.SecurityEvents.Insert(event)
+	if err != nil {
+		a.logError(r, err)
+	}
+}
+
+// readSecurityEventFilters reads the event type and pagination parameters
+// shared by the user and admin listings.
+func (a *applicationDependencies) readSecurityEventFilters(r *http.Request, v *validator.Validator) (string, data.Filters) {
+	qs := r.URL.Query()
+
+	event := a.getSingleQueryParameter(qs, "event", "")
+
+	filters := data.Filters{
+		Page:         a.getSingleIntegerParameter(qs, "page", 1, v),
+		PageSize:     a.getSingleIntegerParameter(qs, "page_size", 20, v),
+		Sort:         a.getSingleQueryParameter(qs, "sort", "-id"),
+		SortSafeList: []string{"id", "-id"},
+	}
+	data.ValidateFilters(v, filters)
+	return event, filters
+}
+
+// listSecurityEventsHandler returns the authenticated user's own audit trail.
+func (a *applicationDependencies) listSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	event, filters := a.readSecurityEventFilters(r, v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	events, metadata, err := a.models.SecurityEvents.GetAll(user.ID, event, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"security_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSecurityEventsAdminHandler lets admins query the audit trail across
+// users, optionally narrowed to one with ?user_id=.
+func (a *applicationDependencies) listSecurityEventsAdminHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	event, filters := a.readSecurityEventFilters(r, v)
+	userID := a.getSingleIntegerParameter(r.URL.Query(), "user_id", 0, v)
+	v.Check(userID >= 0, "user_id", "must not be negative")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := a.models.SecurityEvents.GetAll(int64(userID), event, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"security_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -34,11 +34,15 @@ func (a *applicationDependencies) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
-		shutdownError <- apiServer.Shutdown(ctx)
+		err := apiServer.Shutdown(ctx)
 
+		// Stop the scheduler, maintenance loops and job workers, and wait for
+		// them and any emails they started to finish before serve() returns,
+		// even if the HTTP server didn't shut down cleanly.
 		a.logger.Info("completing background tasks", "address", apiServer.Addr)
+		close(a.shutdown)
 		a.wg.Wait()
-		shutdownError <- nil
+		shutdownError <- err
 	}()
 
 	a.logger.Info("starting server", "address", apiServer.Addr, "environment", a.config.env)
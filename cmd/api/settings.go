@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/mailer"
+	"feel-flow-api/internal/validator"
+)
+
+// showUserSettingsHandler returns the authenticated user's preferences.
+func (a *applicationDependencies) showUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	settings, err := a.models.Settings.Get(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"settings": settings}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserSettingsHandler changes some or all of the authenticated user's
+// preferences.
+func (a *applicationDependencies) updateUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	settings, err := a.models.Settings.Get(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Timezone              *string    `json:"timezone"`
+		Locale                *string    `json:"locale"`
+		WeekStart             *string    `json:"week_start"`
+		Units                 *string    `json:"units"`
+		MoodPalette           []string   `json:"mood_palette"`
+		ReminderTime          *string    `json:"reminder_time"`
+		RemindersPaused       *bool      `json:"reminders_paused"`
+		RemindersSnoozedUntil *time.Time `json:"reminders_snoozed_until"`
+		WeeklyDigest          *bool      `json:"weekly_digest"`
+		DigestDay             *string    `json:"digest_day"`
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Timezone != nil {
+		settings.Timezone = *input.Timezone
+	}
+	if input.Locale != nil {
+		settings.Locale = *input.Locale
+	}
+	if input.WeekStart != nil {
+		settings.WeekStart = *input.WeekStart
+	}
+	if input.Units != nil {
+		settings.Units = *input.Units
+	}
+	if input.MoodPalette != nil {
+		settings.MoodPalette = input.MoodPalette
+	}
+	if input.ReminderTime != nil {
+		settings.ReminderTime = *input.ReminderTime
+	}
+	if input.RemindersPaused != nil {
+		settings.RemindersPaused = *input.RemindersPaused
+	}
+	if input.RemindersSnoozedUntil != nil {
+		// A snooze that has already ended, such as the current time, ends
+		// the snooze.
+		settings.RemindersSnoozedUntil = input.RemindersSnoozedUntil
+		if !input.RemindersSnoozedUntil.After(time.Now()) {
+			settings.RemindersSnoozedUntil = nil
+		}
+	}
+	if input.WeeklyDigest != nil {
+		settings.WeeklyDigest = *input.WeeklyDigest
+	}
+	if input.DigestDay != nil {
+		settings.DigestDay = *input.DigestDay
+	}
+
+	v := validator.New()
+	if data.ValidateUserSettings(v, settings); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Timezone != nil {
+		known, err := a.models.Settings.TimezoneKnown(settings.Timezone)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if !known {
+			v.AddError("timezone", "must be a valid IANA time zone, such as Europe/London")
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	err = a.models.Settings.Save(settings)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"settings": settings}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// userLocale returns the locale the user's emails are written in. If their
+// settings can't be read, the email goes out in the default locale rather
+// than not at all.
+func (a *applicationDependencies) userLocale(userID int64) string {
+	settings, err := a.models.Settings.Get(userID)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return mailer.DefaultLocale
+	}
+	return settings.Locale
+}
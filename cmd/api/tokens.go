@@ -4,10 +4,55 @@ import (
 	"errors"
 	"feel-flow-api/internal/data"
 	"feel-flow-api/internal/validator"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// failedLoginResponse counts a failed login against the account and the
+// client's IP address, emails the user the first time their account is
+// locked, and sends the invalid credentials response. user is nil when the
+// email address doesn't belong to an account.
+func (a *applicationDependencies) failedLoginResponse(w http.ResponseWriter, r *http.Request, email string, user *data.User) {
+	ip := clientIP(r)
+
+	_, _, err := a.models.LoginThrottles.RecordFailure(data.IPThrottleKey(ip), a.config.login.ipLockout)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	policy := a.config.login.accountLockout
+	failures, lockout, err := a.models.LoginThrottles.RecordFailure(data.AccountThrottleKey(email), policy)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil {
+		a.recordSecurityEvent(r, user.ID, data.EventLoginFailed)
+	}
+
+	// Only alert on the first lockout so an attacker can't flood the inbox.
+	if user != nil && failures == policy.Threshold {
+		a.background(func() {
+			emailData := map[string]interface{}{
+				"userName":       user.Name,
+				"failures":       failures,
+				"ipAddress":      ip,
+				"lockoutMinutes": int(math.Ceil(lockout.Minutes())),
+			}
+			err := a.sendEmail(user.ID, user.Email, a.userLocale(user.ID), "login_alert.tmpl", emailData)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		})
+	}
+
+	a.invalidCredentialsResponse(w, r)
+}
+
 func (a *applicationDependencies) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
@@ -28,17 +73,36 @@ func (a *applicationDependencies) createAuthenticationTokenHandler(w http.Respon
 		return
 	}
 
+	// Refuse to even check the password while the account or IP is locked out.
+	retryAfter, err := a.models.LoginThrottles.LockedFor(data.AccountThrottleKey(input.Email), data.IPThrottleKey(clientIP(r)))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		a.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
 	user, err := a.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			a.invalidCredentialsResponse(w, r)
+			data.DummyPasswordCheck(input.Password)
+			a.failedLoginResponse(w, r, input.Email, nil)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// Guest accounts have no password; they use their guest token.
+	if user.Guest {
+		data.DummyPasswordCheck(input.Password)
+		a.failedLoginResponse(w, r, input.Email, nil)
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
@@ -46,18 +110,221 @@ func (a *applicationDependencies) createAuthenticationTokenHandler(w http.Respon
 	}
 
 	if !match {
-		a.invalidCredentialsResponse(w, r)
+		a.failedLoginResponse(w, r, input.Email, user)
+		return
+	}
+
+	// Save the upgraded hash if Matches rehashed a legacy or weaker one. A
+	// failure here shouldn't stop the login; it will be retried next time.
+	if user.Password.Rehashed() {
+		err = a.models.Users.Update(user)
+		if err != nil {
+			a.logger.Error("failed to save rehashed password", "user_id", user.ID, "error", err.Error())
+		}
+	}
+
+	a.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a successful first login step. With two-factor
+// authentication enabled it only issues a short-lived token, which
+// POST /v1/tokens/mfa exchanges for a real one.
+func (a *applicationDependencies) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if user.Locked {
+		a.accountLockedResponse(w, r)
+		return
+	}
+
+	mfaEnabled, err := a.models.TOTP.Enabled(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfaEnabled {
+		mfaToken, err := a.models.Tokens.New(user.ID, mfaPendingTokenTTL, data.ScopeMFAPending)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = a.writeJSON(w, http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": mfaToken}, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	a.issueAuthenticationToken(w, r, user)
+}
+
+// issueAuthenticationToken finishes a login by sending the user a login
+// token. Logging in also cancels a pending deletion of the account. The
+// account's failed login count is only reset here, once every factor has
+// been checked, so logging in again with the password doesn't give an
+// attacker fresh guesses at the second factor.
+func (a *applicationDependencies) issueAuthenticationToken(w http.ResponseWriter, r *http.Request, user *data.User) {
+	err := a.models.LoginThrottles.Reset(data.AccountThrottleKey(user.Email))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cancelled, err := a.models.Users.CancelDeletion(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if cancelled {
+		a.recordSecurityEvent(r, user.ID, data.EventDeletionCancelled)
+	}
+
+	token, err := a.newAuthenticationToken(user)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := a.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	a.recordSecurityEvent(r, user.ID, data.EventLoginSucceeded)
+
+	env := envelope{"authentication_token": token, "user": user}
+	if cancelled {
+		env["deletion_cancelled"] = true
+	}
+	err = a.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler logs out by revoking the token used to make the request.
+func (a *applicationDependencies) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		a.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if a.config.auth.mode == authModeJWT {
+		// Signed tokens can't be deleted, so deny-list their ID until they expire.
+		claims, err := a.verifyJWT(token)
+		if err != nil {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		err = a.models.DenyList.Insert(claims.ID, claims.Expiry())
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		a.denyList.add(claims.ID, claims.Expiry())
+	} else {
+		err := a.models.Tokens.Delete(data.ScopeAuthentication, token)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	a.recordSecurityEvent(r, a.contextGetUser(r).ID, data.EventTokenRevoked)
+
+	err := a.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
+	}
+}
+// revokeSessions signs the user out everywhere. Signed tokens can't be
+// deleted, so in JWT mode every token issued to the user until now is
+// deny-listed instead. API keys and OAuth tokens are left alone.
+func (a *applicationDependencies) revokeSessions(userID int64) error {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeMFAPending} {
+		err := a.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.config.auth.mode == authModeJWT {
+		key := sessionsDenyKey(strconv.FormatInt(userID, 10))
+		expiry := time.Now().Add(authenticationTokenTTL)
+		err := a.models.DenyList.Insert(key, expiry)
+		if err != nil {
+			return err
+		}
+		a.denyList.add(key, expiry)
+	}
+	return nil
+}
+
+// passwordResetTokenTTL is how long a password reset link stays valid.
+const passwordResetTokenTTL = 45 * time.Minute
+
+// createPasswordResetTokenHandler emails a password reset link. It responds
+// the same way whether or not the address has an account so it can't be used
+// to find out who is registered.
+func (a *applicationDependencies) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if that email address has an activated account, you will receive password reset instructions"}
+
+	user, err := a.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = a.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+			}
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = a.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user}, nil)
+	if user.Activated {
+		err = a.sendPasswordResetEmail(user)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
+}
+
+// sendPasswordResetEmail emails the user a new password reset link.
+func (a *applicationDependencies) sendPasswordResetEmail(user *data.User) error {
+	token, err := a.models.Tokens.New(user.ID, passwordResetTokenTTL, data.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	a.background(func() {
+		emailData := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+			"userName":           user.Name,
+		}
+		err := a.sendEmail(user.ID, user.Email, a.userLocale(user.ID), "password_reset.tmpl", emailData)
+		if err != nil && !errors.Is(err, errEmailLimitReached) {
+			a.logger.Error(err.Error())
+		}
+	})
+	return nil
 }
\ No newline at end of file
@@ -11,11 +11,15 @@ import (
 	
 )
 
+// registerUserHandler creates an account and emails its activation link.
+// When a guest token is sent, the guest account is converted in place
+// instead, so the moods logged as a guest are kept.
 func (a *applicationDependencies) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name       string `json:"name"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		GuestToken string `json:"guest_token"`
 	}
 
 	err := a.readJSON(w, r, &input)
@@ -24,55 +28,85 @@ func (a *applicationDependencies) registerUserHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	user := &data.User{
-		Name:      input.Name,
-		Email:     input.Email,
-		Activated: false,
+	v := validator.New()
+
+	user := &data.User{}
+	if input.GuestToken != "" {
+		if data.ValidateGuestTokenPlaintext(v, input.GuestToken); !v.IsEmpty() {
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		user, err = a.models.Users.GetForGuestToken(input.GuestToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("guest_token", "invalid or expired guest token")
+				a.failedValidationResponse(w, r, v.Errors)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
 	}
 
+	user.Name = input.Name
+	user.Email = input.Email
+	user.Activated = false
+	user.Guest = false
+
 	err = user.Password.Set(input.Password)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 
-	v := validator.New()
 	if data.ValidateUser(v, user); !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = a.models.Users.Insert(user)
+	if user.ID == 0 {
+		err = a.models.Users.Insert(user)
+	} else {
+		err = a.models.Users.Update(user)
+	}
 	if err != nil {
-		if errors.Is(err, data.ErrDuplicateEmail) {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			a.failedValidationResponse(w, r, v.Errors)
-		} else {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Generate an activation token.
-	token, err := a.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if input.GuestToken == "" {
+		err = a.models.Permissions.AddForUser(user.ID, data.DefaultPermissions...)
+	} else {
+		// The account signs in with its password from now on.
+		err = a.models.Tokens.DeleteAllForUser(data.ScopeGuest, user.ID)
+	}
 	if err != nil {
-    	a.serverErrorResponse(w, r, err)
-    	return
+		a.serverErrorResponse(w, r, err)
+		return
 	}
 
-	// Use the background helper to send the welcome email.
-	a.background(func() {
-    // Create a map to hold the data for the email template.
-    emailData := map[string]interface{}{
-        "activationToken": token.Plaintext,
-        "userID":          user.ID,
-        "userName":        user.Name,
-    }
-    err = a.mailer.Send(user.Email, "user_welcome.tmpl", emailData)
-    	if err != nil {
-        	a.logger.Error(err.Error())
-    	}
-	})
+	a.recordSecurityEvent(r, user.ID, data.EventRegistered)
+
+	// The welcome email is sent by a job worker, so it isn't lost if this
+	// instance stops before it goes out. The worker issues the activation
+	// token it links to.
+	emailData := map[string]interface{}{
+		"userID":   user.ID,
+		"userName": user.Name,
+	}
+	err = a.enqueueEmail(user.ID, user.Email, a.userLocale(user.ID), "user_welcome.tmpl", emailData)
+	if err != nil && !errors.Is(err, errEmailLimitReached) {
+		a.logger.Error(err.Error())
+	}
 
 	err = a.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
@@ -127,26 +161,73 @@ func (a *applicationDependencies) activateUserHandler(w http.ResponseWriter, r *
 		return
 	}
 
+	a.recordSecurityEvent(r, user.ID, data.EventActivated)
+
 	err = a.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
-func (a *applicationDependencies) updateUserHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := a.readIDParam(r)
+// showCurrentUserHandler returns the authenticated user's profile, which
+// security features they have set up, and a summary of their journal.
+func (a *applicationDependencies) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Signed authentication tokens only carry part of the user record, and
+	// may be out of date, so always load it.
+	user, err := a.models.Users.Get(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	details, err := a.models.Users.GetAccountDetails(user.ID)
 	if err != nil {
-		a.notFoundResponse(w, r)
+		a.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Get the currently authenticated user from the request context.
-	// We will implement this helper function in the next section.
-	user := a.contextGetUser(r)
+	settings, err := a.models.Settings.Get(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	summary, err := a.models.Moods.GetSummary(user.ID, settings.Location(), time.Now())
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
 
-	// Check if the ID from the URL matches the authenticated user's ID.
-	if user.ID != id {
-		a.notPermittedResponse(w, r) // We will create this helper.
+	security := envelope{
+		"mfa_enabled":   details.MFAEnabled,
+		"passkey_count": details.PasskeyCount,
+		"api_key_count": details.APIKeyCount,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"user": user, "security": security, "summary": summary}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserHandler changes the authenticated user's name, email address or
+// password.
+func (a *applicationDependencies) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Signed authentication tokens only carry part of the user record, so load
+	// the full row (including the password hash and version) before updating it.
+	user, err := a.models.Users.Get(a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -162,6 +243,8 @@ func (a *applicationDependencies) updateUserHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	oldEmail := user.Email
+
 	// Update fields if they were provided in the request.
 	if input.Name != nil {
 		user.Name = *input.Name
@@ -197,40 +280,174 @@ func (a *applicationDependencies) updateUserHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if user.Email != oldEmail {
+		a.recordSecurityEvent(r, user.ID, data.EventEmailChanged)
+	}
+	if input.Password != nil {
+		a.recordSecurityEvent(r, user.ID, data.EventPasswordChanged)
+	}
+
 	err = a.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
+// deleteUserHandler schedules the account for deletion after the grace
+// period set by -account-deletion-days, and signs the user out everywhere.
+// Logging in again before then cancels the deletion.
 func (a *applicationDependencies) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := a.readIDParam(r)
+	user := a.contextGetUser(r)
+	id := user.ID
+
+	// Without a grace period the account is deleted straight away. Its
+	// sessions are revoked first, as JWTs would otherwise keep working.
+	if a.config.deletion.grace == 0 {
+		err := a.revokeSessions(id)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = a.models.Users.Delete(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		a.recordSecurityEvent(r, id, data.EventAccountDeleted)
+
+		err = a.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deleteAt := time.Now().Add(a.config.deletion.grace)
+	err := a.models.Users.ScheduleDeletion(id, deleteAt)
 	if err != nil {
-		a.notFoundResponse(w, r)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	
-	// Get the authenticated user.
-	user := a.contextGetUser(r)
 
-	// A user can only delete themselves.
-	if user.ID != id {
-		a.notPermittedResponse(w, r)
+	err = a.revokeSessions(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = a.models.Users.Delete(id)
+	a.recordSecurityEvent(r, id, data.EventDeletionScheduled)
+
+	settings, err := a.models.Settings.Get(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.background(func() {
+		emailData := map[string]interface{}{
+			"userName": user.Name,
+			"deleteAt": deleteAt.In(settings.Location()).Format("2 January 2006"),
+		}
+		err := a.sendEmail(user.ID, user.Email, settings.Locale, "account_deletion.tmpl", emailData)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{
+		"message":               "your account will be deleted at the end of the grace period; log in before then to cancel",
+		"deletion_scheduled_at": deleteAt,
+	}
+	err = a.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserPasswordHandler sets a new password using a token from a
+// password reset email, then signs the user out everywhere.
+func (a *applicationDependencies) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			a.notFoundResponse(w, r)
+			v.AddError("token", "invalid or expired password reset token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateUser(v, user); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = a.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	err = a.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Whoever knew the old password may still be logged in.
+	err = a.revokeSessions(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.recordSecurityEvent(r, user.ID, data.EventPasswordReset)
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"feel-flow-api/internal/data"
+	"feel-flow-api/internal/validator"
+	"feel-flow-api/internal/webauthn"
+	"net/http"
+)
+
+// userHandle is the opaque ID passkeys store for their account. It is the
+// user's ID, which is not personal information.
+func userHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// decodeCredential unpacks the credential a browser returned. Browsers add
+// fields over time (extension results, authenticator attachment), so unlike
+// readJSON this ignores fields it doesn't know.
+func decodeCredential(raw json.RawMessage, dst any) error {
+	if len(raw) == 0 {
+		return errors.New("credential must be provided")
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+// beginPasskeyRegistrationHandler returns the options for
+// navigator.credentials.create() to add a passkey to the user's account.
+func (a *applicationDependencies) beginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	passkeys, err := a.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	exclude := make([]webauthn.CredentialDescriptor, len(passkeys))
+	for i, p := range passkeys {
+		exclude[i] = webauthn.NewCredentialDescriptor(p.Credential.ID, p.Transports)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	err = a.models.WebAuthnChallenges.Insert(challenge, user.ID, data.CeremonyRegistration, a.webauthn.Timeout())
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	options := a.webauthn.CreationOptions(webauthn.User{
+		ID:          userHandle(user.ID),
+		Name:        user.Email,
+		DisplayName: user.Name,
+	}, challenge, exclude)
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"public_key": options}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// finishPasskeyRegistrationHandler verifies the new credential and saves it.
+func (a *applicationDependencies) finishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	var resp webauthn.RegistrationResponse
+	err = decodeCredential(input.Credential, &resp)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidatePasskeyName(v, input.Name); !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	challenge, err := webauthn.ResponseChallenge(resp.Response.ClientDataJSON)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	owner, err := a.models.WebAuthnChallenges.Consume(challenge, data.CeremonyRegistration)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if err != nil || owner != user.ID {
+		v.AddError("credential", "registration has expired or was started by another account; please try again")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cred, err := a.webauthn.VerifyRegistration(challenge, &resp)
+	if err != nil {
+		v.AddError("credential", err.Error())
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	passkey := &data.Passkey{
+		Name:       input.Name,
+		UserID:     user.ID,
+		Credential: *cred,
+	}
+	err = a.models.Passkeys.Insert(passkey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePasskey):
+			v.AddError("credential", "this passkey is already registered")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"passkey": passkey}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// beginPasskeyLoginHandler returns the options for navigator.credentials.get().
+// With an email address it lists that account's passkeys; without one the
+// browser offers any passkey it has for this site.
+func (a *applicationDependencies) beginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	var userID int64
+	allow := []webauthn.CredentialDescriptor{}
+
+	if input.Email != "" {
+		v := validator.New()
+		if data.ValidateEmail(v, input.Email); !v.IsEmpty() {
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// An unknown address gets an empty list, the same as an account
+		// without passkeys, so this can't be used to look up accounts.
+		user, err := a.models.Users.GetByEmail(input.Email)
+		switch {
+		case err == nil:
+			passkeys, err := a.models.Passkeys.GetAllForUser(user.ID)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			for _, p := range passkeys {
+				allow = append(allow, webauthn.NewCredentialDescriptor(p.Credential.ID, p.Transports))
+			}
+			userID = user.ID
+		case !errors.Is(err, data.ErrRecordNotFound):
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	err = a.models.WebAuthnChallenges.Insert(challenge, userID, data.CeremonyLogin, a.webauthn.Timeout())
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"public_key": a.webauthn.RequestOptions(challenge, allow)}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// finishPasskeyLoginHandler verifies the signed challenge and issues an
+// authentication token. A passkey is already two factors (the device and the
+// user's PIN or biometrics, which VerifyAssertion requires the authenticator
+// to have checked), so TOTP isn't asked for.
+func (a *applicationDependencies) finishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Credential json.RawMessage `json:"credential"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	var resp webauthn.AssertionResponse
+	err = decodeCredential(input.Credential, &resp)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	challenge, err := webauthn.ResponseChallenge(resp.Response.ClientDataJSON)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	boundUserID, err := a.models.WebAuthnChallenges.Consume(challenge, data.CeremonyLogin)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	passkey, err := a.models.Passkeys.GetByCredentialID(resp.RawID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if boundUserID != 0 && boundUserID != passkey.UserID {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+	if len(resp.Response.UserHandle) > 0 && string(resp.Response.UserHandle) != string(userHandle(passkey.UserID)) {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	signCount, err := a.webauthn.VerifyAssertion(challenge, &passkey.Credential, &resp)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCount) {
+			a.logger.Warn("passkey signature counter went backwards", "passkey_id", passkey.ID, "user_id", passkey.UserID)
+		}
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = a.models.Passkeys.RecordUse(passkey.ID, signCount)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := a.models.Users.Get(passkey.UserID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if user.Locked {
+		a.accountLockedResponse(w, r)
+		return
+	}
+
+	a.issueAuthenticationToken(w, r, user)
+}
+
+func (a *applicationDependencies) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	passkeys, err := a.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"passkeys": passkeys}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	err = a.models.Passkeys.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"message": "passkey successfully deleted"}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
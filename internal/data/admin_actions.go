@@ -0,0 +1,70 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Actions an admin can take on an account.
+const (
+	AdminActionActivate      = "activate"
+	AdminActionDeactivate    = "deactivate"
+	AdminActionLock          = "lock"
+	AdminActionUnlock        = "unlock"
+	AdminActionLogout        = "logout"
+	AdminActionPasswordReset = "password_reset"
+)
+
+// AdminAction records an admin acting on a user's account. Neither ID is a
+// foreign key, so the record outlives both accounts.
+type AdminAction struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	AdminID   int64     `json:"admin_id"`
+	UserID    int64     `json:"user_id"`
+	Action    string    `json:"action"`
+}
+
+// AdminActionModel stores the audit trail of admin actions.
+type AdminActionModel struct {
+	DB *sql.DB
+}
+
+func (m *AdminActionModel) Insert(adminID, userID int64, action string) error {
+	query := `
+		INSERT INTO admin_actions (admin_id, user_id, action)
+		VALUES ($1, $2, $3)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, adminID, userID, action)
+	return err
+}
+
+// GetAllForUser returns the actions taken on the user's account, newest first.
+func (m *AdminActionModel) GetAllForUser(userID int64) ([]*AdminAction, error) {
+	query := `
+		SELECT id, created_at, admin_id, user_id, action
+		FROM admin_actions
+		WHERE user_id = $1
+		ORDER BY id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*AdminAction{}
+	for rows.Next() {
+		var action AdminAction
+		err := rows.Scan(&action.ID, &action.CreatedAt, &action.AdminID, &action.UserID, &action.Action)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, &action)
+	}
+	return actions, rows.Err()
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "alice", escapeLike("alice"))
+	assert.Equal(t, `100\%`, escapeLike("100%"))
+	assert.Equal(t, `a\_b`, escapeLike("a_b"))
+	assert.Equal(t, `a\\b`, escapeLike(`a\b`))
+}
+
+func TestAdminModels_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Search accounts", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		for _, u := range []*User{
+			{Name: "Alice Smith", Email: "alice@example.com", Activated: true},
+			{Name: "Bob Jones", Email: "bob@example.com", Activated: false},
+			{Name: "Carol Smith", Email: "carol@example.org", Activated: true},
+		} {
+			_ = u.Password.Set("password123")
+			assert.NoError(t, userModel.Insert(u))
+		}
+
+		filters := Filters{Page: 1, PageSize: 2, Sort: "name", SortSafeList: []string{"name"}}
+
+		accounts, metadata, err := userModel.Search("smith", nil, nil, filters)
+		assert.NoError(t, err)
+		assert.Len(t, accounts, 2)
+		assert.Equal(t, "Alice Smith", accounts[0].Name)
+		assert.Equal(t, int64(2), metadata.TotalRecords)
+
+		accounts, metadata, err = userModel.Search("example", nil, nil, filters)
+		assert.NoError(t, err)
+		assert.Len(t, accounts, 2)
+		assert.Equal(t, 2, metadata.LastPage)
+
+		activated := false
+		accounts, _, err = userModel.Search("", &activated, nil, filters)
+		assert.NoError(t, err)
+		assert.Len(t, accounts, 1)
+		assert.Equal(t, "bob@example.com", accounts[0].Email)
+
+		// LIKE wildcards in the query are matched literally.
+		accounts, _, err = userModel.Search("%", nil, nil, filters)
+		assert.NoError(t, err)
+		assert.Empty(t, accounts)
+	})
+
+	t.Run("Details, locking and actions", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		moodModel := MoodModel{DB: db}
+		tokenModel := TokenModel{DB: db}
+		actionModel := AdminActionModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		for i := 0; i < 3; i++ {
+			mood := &Mood{Title: "Day", Content: "private", Emotion: "calm", Emoji: "🙂", Color: "blue", UserID: user.ID}
+			assert.NoError(t, moodModel.Insert(mood))
+		}
+
+		details, err := userModel.GetAccountDetails(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), details.MoodCount)
+		assert.NotNil(t, details.LastMoodAt)
+		assert.False(t, details.Locked)
+		assert.False(t, details.MFAEnabled)
+
+		_, err = userModel.GetAccountDetails(user.ID + 100)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		token, _ := tokenModel.New(user.ID, time.Hour, ScopeAuthentication)
+
+		assert.NoError(t, userModel.SetLocked(user.ID, true))
+		assert.ErrorIs(t, userModel.SetLocked(user.ID+100, true), ErrRecordNotFound)
+
+		locked, err := userModel.GetByEmail("test@example.com")
+		assert.NoError(t, err)
+		assert.True(t, locked.Locked)
+
+		// Tokens stop working while the account is locked.
+		_, err = userModel.GetForToken(ScopeAuthentication, token.Plaintext)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		assert.NoError(t, userModel.SetLocked(user.ID, false))
+		_, err = userModel.GetForToken(ScopeAuthentication, token.Plaintext)
+		assert.NoError(t, err)
+
+		assert.NoError(t, actionModel.Insert(42, user.ID, AdminActionLock))
+		assert.NoError(t, actionModel.Insert(42, user.ID, AdminActionUnlock))
+
+		actions, err := actionModel.GetAllForUser(user.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, actions, 2) {
+			assert.Equal(t, AdminActionUnlock, actions[0].Action)
+			assert.Equal(t, int64(42), actions[0].AdminID)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"feel-flow-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix marks a bearer token as a personal API key rather than a login token.
+const APIKeyPrefix = "ffk_"
+
+// Scopes that can be granted to an API key.
+const (
+	ScopeMoodsRead  = "moods:read"
+	ScopeMoodsWrite = "moods:write"
+)
+
+// APIKeyScopes lists every scope an API key may be granted.
+var APIKeyScopes = []string{ScopeMoodsRead, ScopeMoodsWrite}
+
+// MaxAPIKeyLifetime is the longest expiry a user can give an API key.
+const MaxAPIKeyLifetime = 365 * 24 * time.Hour
+
+var apiKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIKey is a long-lived, user-managed credential for scripts and integrations.
+// Keys look like "ffk_<id>_<secret>"; only the SHA-256 hash of the full key is
+// stored, plus the "ffk_<id>" prefix so users can recognise their keys.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Plaintext  string     `json:"key,omitempty"` // Only set when the key is first created.
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return validator.PermittedValue(scope, k.Scopes...)
+}
+
+// generateAPIKey creates a new APIKey with a random identifier and secret.
+func generateAPIKey(userID int64, name string, scopes []string, expiry time.Time) (*APIKey, error) {
+	randomBytes := make([]byte, 25)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		Name:   name,
+		UserID: userID,
+		Scopes: scopes,
+		Expiry: expiry,
+	}
+	key.Prefix = APIKeyPrefix + apiKeyEncoding.EncodeToString(randomBytes[:5])
+	key.Plaintext = key.Prefix + "_" + apiKeyEncoding.EncodeToString(randomBytes[5:])
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one scope")
+	for _, scope := range key.Scopes {
+		v.Check(validator.PermittedValue(scope, APIKeyScopes...), "scopes", "must only contain "+strings.Join(APIKeyScopes, ", "))
+	}
+
+	v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	v.Check(key.Expiry.Before(time.Now().Add(MaxAPIKeyLifetime)), "expiry", "must be no more than a year from now")
+}
+
+// ValidateAPIKeyLifetime checks the number of days a new key should last
+// before it is turned into an expiry, so large values can't overflow.
+func ValidateAPIKeyLifetime(v *validator.Validator, days int) {
+	v.Check(days >= 1, "expires_in_days", "must be at least 1")
+	v.Check(days <= int(MaxAPIKeyLifetime/(24*time.Hour)), "expires_in_days", "must be no more than 365")
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(strings.HasPrefix(keyPlaintext, APIKeyPrefix), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+8+1+32, "key", "must be 45 bytes long")
+}
+
+// APIKeyModel manages API keys in the database.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// New generates a key and inserts it. The returned key holds the plaintext,
+// which is never available again.
+func (m *APIKeyModel) New(userID int64, name string, scopes []string, expiry time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, scopes, expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(key)
+	return key, err
+}
+
+func (m *APIKeyModel) Insert(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.Expiry}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetAllForUser returns the user's keys, newest first, without their secrets.
+func (m *APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, name, prefix, scopes, expiry, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := APIKey{UserID: userID}
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.Expiry,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	return keys, rows.Err()
+}
+
+// GetForKey looks up an unexpired key and its owner, recording the use.
+func (m *APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, *User, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		FROM users
+		WHERE api_keys.hash = $1
+		AND api_keys.expiry > $2
+		AND users.id = api_keys.user_id
+		AND users.locked = false
+		RETURNING api_keys.id, api_keys.created_at, api_keys.name, api_keys.prefix, api_keys.scopes,
+			api_keys.expiry, api_keys.last_used_at,
+			users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version`
+
+	var key APIKey
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.Expiry,
+		&key.LastUsedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrRecordNotFound
+		}
+		return nil, nil, err
+	}
+	key.UserID = user.ID
+	return &key, &user, nil
+}
+
+// Delete revokes one of the user's keys.
+func (m *APIKeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"feel-flow-api/internal/validator"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAPIKeyLifetime(t *testing.T) {
+	for days, valid := range map[int]bool{1: true, 90: true, 365: true, 0: false, -1: false, 366: false, 1 << 40: false} {
+		v := validator.New()
+		ValidateAPIKeyLifetime(v, days)
+		assert.Equal(t, valid, v.IsEmpty(), "days %d", days)
+	}
+}
+
+func TestAPIKeyModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("New, GetForKey and Delete", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		apiKeyModel := APIKeyModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		key, err := apiKeyModel.New(user.ID, "nightly export", []string{ScopeMoodsRead}, time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(key.Plaintext, key.Prefix+"_"))
+
+		// The plaintext key finds the key and its owner.
+		found, owner, err := apiKeyModel.GetForKey(key.Plaintext)
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID, found.ID)
+		assert.Equal(t, user.ID, owner.ID)
+		assert.True(t, found.HasScope(ScopeMoodsRead))
+		assert.False(t, found.HasScope(ScopeMoodsWrite))
+		assert.NotNil(t, found.LastUsedAt)
+
+		// Listing never returns the secret.
+		keys, err := apiKeyModel.GetAllForUser(user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, keys, 1)
+		assert.Empty(t, keys[0].Plaintext)
+
+		// Another user can't revoke the key.
+		assert.Equal(t, ErrRecordNotFound, apiKeyModel.Delete(key.ID, user.ID+1))
+
+		assert.NoError(t, apiKeyModel.Delete(key.ID, user.ID))
+		_, _, err = apiKeyModel.GetForKey(key.Plaintext)
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DeniedToken identifies a revoked signed token by its jti claim. The entry
+// only needs to live as long as the token itself would have.
+type DeniedToken struct {
+	ID     string
+	Expiry time.Time
+}
+
+// DenyListModel stores revoked signed tokens.
+type DenyListModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a token ID to the deny-list. Revoking the same token twice is
+// not an error; the later of the two expiries is kept.
+func (m *DenyListModel) Insert(id string, expiry time.Time) error {
+	query := `
+		INSERT INTO token_denylist (jti, expiry)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO UPDATE SET expiry = GREATEST(token_denylist.expiry, EXCLUDED.expiry)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id, expiry)
+	return err
+}
+
+// GetAllActive returns every deny-list entry whose token has not yet expired.
+func (m *DenyListModel) GetAllActive() ([]DeniedToken, error) {
+	query := `
+		SELECT jti, expiry
+		FROM token_denylist
+		WHERE expiry > $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	denied := []DeniedToken{}
+	for rows.Next() {
+		var d DeniedToken
+		if err := rows.Scan(&d.ID, &d.Expiry); err != nil {
+			return nil, err
+		}
+		denied = append(denied, d)
+	}
+	return denied, rows.Err()
+}
+
+// DeleteExpired removes entries whose tokens have expired anyway.
+func (m *DenyListModel) DeleteExpired() error {
+	query := `DELETE FROM token_denylist WHERE expiry <= $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
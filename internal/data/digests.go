@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// digestTopEmotions is how many of the week's most logged emotions a digest
+// lists.
+const digestTopEmotions = 3
+
+// WeeklyDigest summarizes the seven days before a user's digest is sent.
+// It never includes the content of their moods.
+type WeeklyDigest struct {
+	PeriodStart      time.Time      `json:"period_start"`
+	PeriodEnd        time.Time      `json:"period_end"`
+	EntryCount       int            `json:"entry_count"`
+	DominantEmotions []EmotionCount `json:"dominant_emotions"`
+	// Days is the week's color strip, oldest first.
+	Days          []DigestDay `json:"days"`
+	CurrentStreak int         `json:"current_streak"`
+}
+
+// EmotionCount is how many times an emotion was logged.
+type EmotionCount struct {
+	Emotion string `json:"emotion"`
+	Count   int    `json:"count"`
+}
+
+// DigestDay is one day of a digest's color strip. Color is the color of the
+// last mood logged that day, or "" if there were none.
+type DigestDay struct {
+	Date      time.Time `json:"date"`
+	Weekday   string    `json:"weekday"`
+	Color     string    `json:"color"`
+	MoodCount int       `json:"mood_count"`
+}
+
+// GetWeeklyDigest builds the digest for the seven days in loc that end at
+// the start of the day containing now.
+func (m MoodModel) GetWeeklyDigest(userID int64, loc *time.Location, now time.Time) (*WeeklyDigest, error) {
+	local := now.In(loc)
+	end := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	start := end.AddDate(0, 0, -7)
+
+	query := `
+		SELECT created_at, emotion, color
+		FROM moods
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	moods := []*Mood{}
+	for rows.Next() {
+		var mood Mood
+		if err := rows.Scan(&mood.CreatedAt, &mood.Emotion, &mood.Color); err != nil {
+			return nil, err
+		}
+		moods = append(moods, &mood)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	digest := buildWeeklyDigest(moods, start)
+
+	summary, err := m.GetSummary(userID, loc, now)
+	if err != nil {
+		return nil, err
+	}
+	digest.CurrentStreak = summary.CurrentStreak
+	return digest, nil
+}
+
+// buildWeeklyDigest summarizes moods, oldest first, logged in the seven days
+// from start, which is midnight in the user's time zone.
+func buildWeeklyDigest(moods []*Mood, start time.Time) *WeeklyDigest {
+	digest := &WeeklyDigest{
+		PeriodStart:      start,
+		PeriodEnd:        start.AddDate(0, 0, 7),
+		EntryCount:       len(moods),
+		DominantEmotions: []EmotionCount{},
+	}
+
+	for i := range 7 {
+		date := start.AddDate(0, 0, i)
+		digest.Days = append(digest.Days, DigestDay{Date: date, Weekday: date.Weekday().String()})
+	}
+
+	counts := map[string]int{}
+	for _, mood := range moods {
+		counts[mood.Emotion]++
+		local := mood.CreatedAt.In(start.Location())
+		for i := range digest.Days {
+			day := &digest.Days[i]
+			if sameDate(day.Date, local) {
+				day.Color = mood.Color
+				day.MoodCount++
+			}
+		}
+	}
+
+	for emotion, count := range counts {
+		digest.DominantEmotions = append(digest.DominantEmotions, EmotionCount{Emotion: emotion, Count: count})
+	}
+	sort.Slice(digest.DominantEmotions, func(i, j int) bool {
+		a, b := digest.DominantEmotions[i], digest.DominantEmotions[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Emotion < b.Emotion
+	})
+	if len(digest.DominantEmotions) > digestTopEmotions {
+		digest.DominantEmotions = digest.DominantEmotions[:digestTopEmotions]
+	}
+
+	return digest
+}
+
+// DueDigest is a user whose weekly digest should be sent. Week is the first
+// day of the user's current week.
+type DueDigest struct {
+	UserID int64
+	Name   string
+	Email  string
+	Week   time.Time
+}
+
+// DigestModel tracks weekly digest deliveries.
+type DigestModel struct {
+	DB *sql.DB
+}
+
+// ClaimDue records a delivery for up to limit users who opted in to the
+// weekly digest, for whom it is their digest day and past sendAt ("HH:MM")
+// in their time zone, and who haven't been sent a digest this week. It
+// returns the users it recorded. Deliveries are keyed by user and the first
+// day of their week under week_start, so API instances running at the same
+// time never send the same digest twice, and changing the digest day doesn't
+// send a second one in the same week.
+func (m *DigestModel) ClaimDue(sendAt string, limit int) ([]*DueDigest, error) {
+	query := `
+		WITH due AS (
+			SELECT s.user_id,
+				tz.today - ((EXTRACT(DOW FROM tz.today)::int
+					- CASE s.week_start WHEN 'sunday' THEN 0 WHEN 'saturday' THEN 6 ELSE 1 END + 7) % 7) AS week
+			FROM user_settings s
+			INNER JOIN users u ON u.id = s.user_id
+			CROSS JOIN LATERAL (
+				SELECT NOW() AT TIME ZONE s.timezone AS now, (NOW() AT TIME ZONE s.timezone)::date AS today
+			) tz
+			WHERE s.weekly_digest = true
+			AND lower(to_char(tz.now, 'FMDay')) = s.digest_day
+			AND tz.now::time >= $1::time
+			AND u.activated = true AND u.locked = false AND u.deletion_scheduled_at IS NULL
+		), claimed AS (
+			INSERT INTO digest_deliveries (user_id, week)
+			SELECT due.user_id, due.week
+			FROM due
+			WHERE NOT EXISTS (
+				SELECT 1 FROM digest_deliveries d
+				WHERE d.user_id = due.user_id AND d.week = due.week
+			)
+			ORDER BY due.user_id
+			LIMIT $2
+			ON CONFLICT DO NOTHING
+			RETURNING user_id, week
+		)
+		SELECT users.id, users.name, users.email, claimed.week
+		FROM claimed
+		INNER JOIN users ON users.id = claimed.user_id`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, sendAt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	digests := []*DueDigest{}
+	for rows.Next() {
+		var digest DueDigest
+		err := rows.Scan(&digest.UserID, &digest.Name, &digest.Email, &digest.Week)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, &digest)
+	}
+	return digests, rows.Err()
+}
+
+// MarkSent records that a claimed digest was emailed.
+func (m *DigestModel) MarkSent(userID int64, week time.Time) error {
+	query := `
+		UPDATE digest_deliveries SET sent_at = NOW()
+		WHERE user_id = $1 AND week = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, week)
+	return err
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildWeeklyDigest(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Belize")
+	start := time.Date(2025, time.March, 2, 0, 0, 0, 0, loc)
+	at := func(day, hour int) time.Time { return time.Date(2025, time.March, day, hour, 0, 0, 0, loc).UTC() }
+
+	moods := []*Mood{
+		{CreatedAt: at(2, 9), Emotion: "Calm", Color: "#AAAAAA"},
+		{CreatedAt: at(2, 21), Emotion: "Happy", Color: "#FFD93D"},
+		{CreatedAt: at(4, 23), Emotion: "Calm", Color: "#4D96FF"},
+		{CreatedAt: at(5, 8), Emotion: "Anxious", Color: "#FF6B6B"},
+		{CreatedAt: at(8, 12), Emotion: "Tired", Color: "#95A5A6"},
+	}
+
+	digest := buildWeeklyDigest(moods, start)
+	assert.Equal(t, 5, digest.EntryCount)
+	assert.Equal(t, start.AddDate(0, 0, 7), digest.PeriodEnd)
+
+	// Ties are broken alphabetically, and only the top three are listed.
+	assert.Equal(t, []EmotionCount{{"Calm", 2}, {"Anxious", 1}, {"Happy", 1}}, digest.DominantEmotions)
+
+	if assert.Len(t, digest.Days, 7) {
+		assert.Equal(t, "Sunday", digest.Days[0].Weekday)
+		// Each day takes the color of its last mood, in the user's time zone.
+		assert.Equal(t, "#FFD93D", digest.Days[0].Color)
+		assert.Equal(t, 2, digest.Days[0].MoodCount)
+		assert.Equal(t, "", digest.Days[1].Color)
+		assert.Equal(t, "#4D96FF", digest.Days[2].Color)
+		assert.Equal(t, "#FF6B6B", digest.Days[3].Color)
+		assert.Equal(t, "#95A5A6", digest.Days[6].Color)
+	}
+}
+
+func TestDigestModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("ClaimDue", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		settingsModel := UserSettingsModel{DB: db}
+		digestModel := DigestModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		assert.NoError(t, userModel.Insert(user))
+
+		settings := DefaultUserSettings(user.ID)
+		settings.WeeklyDigest = true
+		settings.DigestDay = strings.ToLower(time.Now().UTC().Weekday().String())
+		assert.NoError(t, settingsModel.Save(settings))
+
+		digests, err := digestModel.ClaimDue("00:00", 100)
+		assert.NoError(t, err)
+		if assert.Len(t, digests, 1) {
+			assert.Equal(t, user.ID, digests[0].UserID)
+			assert.NoError(t, digestModel.MarkSent(user.ID, digests[0].Week))
+		}
+
+		// The same week is never claimed twice.
+		digests, err = digestModel.ClaimDue("00:00", 100)
+		assert.NoError(t, err)
+		assert.Empty(t, digests)
+	})
+
+	t.Run("ClaimDue skips a week already sent on another day", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		settingsModel := UserSettingsModel{DB: db}
+		digestModel := DigestModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		assert.NoError(t, userModel.Insert(user))
+
+		now := time.Now().UTC()
+		settings := DefaultUserSettings(user.ID)
+		settings.WeeklyDigest = true
+		settings.DigestDay = strings.ToLower(now.Weekday().String())
+		assert.NoError(t, settingsModel.Save(settings))
+
+		// A digest was sent earlier this week, before the digest day changed.
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		week := today.AddDate(0, 0, -int((today.Weekday()-settings.FirstDayOfWeek()+7)%7))
+		_, err := db.Exec(`INSERT INTO digest_deliveries (user_id, week, sent_at) VALUES ($1, $2, NOW())`, user.ID, week)
+		assert.NoError(t, err)
+
+		digests, err := digestModel.ClaimDue("00:00", 100)
+		assert.NoError(t, err)
+		assert.Empty(t, digests)
+	})
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Email statuses. A failed email that went through the job queue is tried
+// again until the job runs out of attempts.
+const (
+	EmailQueued = "queued"
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
+
+// EmailStatuses lists every email status, for validating filters.
+var EmailStatuses = []string{EmailQueued, EmailSent, EmailFailed}
+
+// EmailMessage is one entry in the outbound email log. Data is what the
+// template was rendered with, minus tokens and anything else private, which
+// are never stored; resending an email issues fresh ones. UserID is not a
+// foreign key, so the log outlives the account.
+type EmailMessage struct {
+	ID        int64                  `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	UserID    int64                  `json:"user_id"`
+	Recipient string                 `json:"recipient"`
+	Template  string                 `json:"template"`
+	Locale    string                 `json:"locale"`
+	Subject   string                 `json:"subject"`
+	Data      map[string]interface{} `json:"data"`
+	Status    string                 `json:"status"`
+	Attempts  int                    `json:"attempts"`
+	LastError string                 `json:"last_error,omitempty"`
+	SentAt    *time.Time             `json:"sent_at"`
+}
+
+// EmailModel stores the outbound email log.
+type EmailModel struct {
+	DB *sql.DB
+}
+
+// Insert adds email to the log as queued.
+func (m EmailModel) Insert(email *EmailMessage) error {
+	emailData, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_messages (user_id, recipient, template, locale, subject, data)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at, status`
+	args := []interface{}{email.UserID, email.Recipient, email.Template, email.Locale, email.Subject, string(emailData)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&email.ID, &email.CreatedAt, &email.UpdatedAt, &email.Status)
+}
+
+// emailColumns are the columns scanned by scanEmail.
+const emailColumns = `id, created_at, updated_at, user_id, recipient, template, locale, subject, data,
+	status, attempts, last_error, sent_at`
+
+func scanEmail(scan func(dest ...interface{}) error, email *EmailMessage, extra ...interface{}) error {
+	var emailData []byte
+	dest := append(extra,
+		&email.ID,
+		&email.CreatedAt,
+		&email.UpdatedAt,
+		&email.UserID,
+		&email.Recipient,
+		&email.Template,
+		&email.Locale,
+		&email.Subject,
+		&emailData,
+		&email.Status,
+		&email.Attempts,
+		&email.LastError,
+		&email.SentAt,
+	)
+	err := scan(dest...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(emailData, &email.Data)
+}
+
+// Get returns the logged email with the given ID.
+func (m EmailModel) Get(id int64) (*EmailMessage, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + emailColumns + ` FROM email_messages WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var email EmailMessage
+	err := scanEmail(m.DB.QueryRowContext(ctx, query, id).Scan, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &email, nil
+}
+
+// GetAll returns a page of the log. recipient and status are only filtered
+// on when they are not empty.
+func (m EmailModel) GetAll(recipient, status string, filters Filters) ([]*EmailMessage, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM email_messages
+		WHERE (recipient = $1 OR $1 = '')
+		AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, emailColumns, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, recipient, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	emails := []*EmailMessage{}
+	for rows.Next() {
+		var e EmailMessage
+		err := scanEmail(rows.Scan, &e, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		emails = append(emails, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return emails, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// RecordAttempt records an attempt to send the email, which failed with
+// sendErr or succeeded if it is nil.
+func (m EmailModel) RecordAttempt(id int64, sendErr error) error {
+	status, lastError := EmailSent, ""
+	if sendErr != nil {
+		status, lastError = EmailFailed, sendErr.Error()
+	}
+
+	query := `
+		UPDATE email_messages
+		SET status = $2, attempts = attempts + 1, last_error = $3, updated_at = NOW(),
+			sent_at = CASE WHEN $2 = 'sent' THEN NOW() ELSE sent_at END
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id, status, lastError)
+	return err
+}
+
+// Requeue marks the email as queued to be sent again.
+func (m EmailModel) Requeue(id int64) error {
+	query := `
+		UPDATE email_messages
+		SET status = 'queued', updated_at = NOW()
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountSince returns how many emails rendered from templateFile have been
+// logged for recipient since the given time, whatever their status.
+func (m EmailModel) CountSince(recipient, templateFile string, since time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM email_messages
+		WHERE recipient = $1 AND template = $2 AND created_at > $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, recipient, templateFile, since).Scan(&count)
+	return count, err
+}
+
+// CountForUserSince returns how many emails rendered from any of templates
+// have been logged for the user since the given time. Unlike counting the
+// tokens they link to, the count isn't reset when tokens expire or are used.
+func (m EmailModel) CountForUserSince(userID int64, since time.Time, templates ...string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM email_messages
+		WHERE user_id = $1 AND template = ANY($2) AND created_at > $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID, pq.Array(templates), since).Scan(&count)
+	return count, err
+}
+
+// DeleteOlderThan deletes emails logged longer than age ago and returns how
+// many were deleted.
+func (m EmailModel) DeleteOlderThan(age time.Duration) (int64, error) {
+	query := `DELETE FROM email_messages WHERE created_at < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
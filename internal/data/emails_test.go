@@ -0,0 +1,103 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Insert, RecordAttempt and GetAll", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		emailModel := EmailModel{DB: db}
+
+		welcome := &EmailMessage{UserID: 1, Recipient: "alice@example.com", Template: "user_welcome.tmpl", Locale: "en", Subject: "Welcome", Data: map[string]interface{}{"userName": "Alice", "failures": 5}}
+		assert.NoError(t, emailModel.Insert(welcome))
+		assert.NotZero(t, welcome.ID)
+		assert.Equal(t, EmailQueued, welcome.Status)
+
+		reset := &EmailMessage{UserID: 2, Recipient: "bob@example.com", Template: "password_reset.tmpl", Locale: "es", Subject: "Restablece", Data: map[string]interface{}{}}
+		assert.NoError(t, emailModel.Insert(reset))
+
+		assert.NoError(t, emailModel.RecordAttempt(welcome.ID, errors.New("connection refused")))
+		email, err := emailModel.Get(welcome.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, EmailFailed, email.Status)
+		assert.Equal(t, 1, email.Attempts)
+		assert.Equal(t, "connection refused", email.LastError)
+		assert.Nil(t, email.SentAt)
+		assert.Equal(t, int64(1), email.UserID)
+		assert.Equal(t, map[string]interface{}{"userName": "Alice", "failures": float64(5)}, email.Data)
+
+		assert.NoError(t, emailModel.Requeue(welcome.ID))
+		assert.NoError(t, emailModel.RecordAttempt(welcome.ID, nil))
+		email, err = emailModel.Get(welcome.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, EmailSent, email.Status)
+		assert.Equal(t, 2, email.Attempts)
+		assert.Empty(t, email.LastError)
+		assert.NotNil(t, email.SentAt)
+
+		filters := Filters{Page: 1, PageSize: 20, Sort: "-id", SortSafeList: []string{"id", "-id"}}
+
+		emails, metadata, err := emailModel.GetAll("", "", filters)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), metadata.TotalRecords)
+		if assert.Len(t, emails, 2) {
+			assert.Equal(t, reset.ID, emails[0].ID)
+			assert.Equal(t, "es", emails[0].Locale)
+		}
+
+		// Recipients are compared without case.
+		emails, _, err = emailModel.GetAll("Alice@Example.com", "", filters)
+		assert.NoError(t, err)
+		assert.Len(t, emails, 1)
+
+		emails, _, err = emailModel.GetAll("", EmailQueued, filters)
+		assert.NoError(t, err)
+		if assert.Len(t, emails, 1) {
+			assert.Equal(t, "bob@example.com", emails[0].Recipient)
+		}
+
+		_, err = emailModel.Get(999)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+		assert.ErrorIs(t, emailModel.Requeue(999), ErrRecordNotFound)
+	})
+
+	t.Run("Counting and DeleteOlderThan", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		emailModel := EmailModel{DB: db}
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, emailModel.Insert(&EmailMessage{UserID: 1, Recipient: "alice@example.com", Template: "magic_link.tmpl", Locale: "en"}))
+		}
+		assert.NoError(t, emailModel.Insert(&EmailMessage{UserID: 1, Recipient: "alice@example.com", Template: "login_alert.tmpl", Locale: "en"}))
+		_, err := db.Exec(`UPDATE email_messages SET created_at = NOW() - INTERVAL '2 hours' WHERE id = 1`)
+		assert.NoError(t, err)
+
+		// Each template is counted separately.
+		count, err := emailModel.CountSince("ALICE@example.com", "magic_link.tmpl", time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		count, err = emailModel.CountForUserSince(1, time.Now().Add(-time.Hour), "magic_link.tmpl", "login_alert.tmpl")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+		count, err = emailModel.CountForUserSince(2, time.Now().Add(-time.Hour), "magic_link.tmpl")
+		assert.NoError(t, err)
+		assert.Zero(t, count)
+
+		deleted, err := emailModel.DeleteOlderThan(time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+	})
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Data export statuses.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// ErrExportInProgress is returned when the user already has an export being built.
+var ErrExportInProgress = errors.New("an export is already in progress")
+
+// exportStaleAfter is how long a pending export may take before it is
+// assumed to have died with its API instance and can be started again.
+const exportStaleAfter = time.Hour
+
+// ExportSource loads one kind of a user's data for their export archive.
+// The value it returns is written to the archive as JSON.
+type ExportSource func(userID int64) (any, error)
+
+// Exporter builds a user's data export: a zip archive with one JSON file per
+// registered source. Tables holding per-user data should register a source
+// here so exports stay complete.
+type Exporter struct {
+	names   []string
+	sources map[string]ExportSource
+}
+
+// NewExporter returns an exporter with a source for every kind of user data
+// the models hold.
+func NewExporter(models Models) *Exporter {
+	e := &Exporter{}
+	e.Register("profile", func(userID int64) (any, error) {
+		return models.Users.Get(userID)
+	})
+	e.Register("account", func(userID int64) (any, error) {
+		return models.Users.GetAccountDetails(userID)
+	})
+	e.Register("settings", func(userID int64) (any, error) {
+		return models.Settings.Get(userID)
+	})
+	e.Register("moods", func(userID int64) (any, error) {
+		return models.Moods.GetAllForUser(userID)
+	})
+	e.Register("tokens", func(userID int64) (any, error) {
+		return models.Tokens.GetAllActiveForUser(userID)
+	})
+	e.Register("api_keys", func(userID int64) (any, error) {
+		return models.APIKeys.GetAllForUser(userID)
+	})
+	e.Register("passkeys", func(userID int64) (any, error) {
+		return models.Passkeys.GetAllForUser(userID)
+	})
+	e.Register("oauth_grants", func(userID int64) (any, error) {
+		return models.OAuthGrants.GetAllForUser(userID)
+	})
+	e.Register("identities", func(userID int64) (any, error) {
+		return models.Identities.GetAllForUser(userID)
+	})
+	e.Register("security_events", func(userID int64) (any, error) {
+		return models.SecurityEvents.GetAllForUser(userID)
+	})
+	return e
+}
+
+// Register adds a source, written to the archive as name.json. Registering
+// the same name twice is a programming error and panics.
+func (e *Exporter) Register(name string, source ExportSource) {
+	if e.sources == nil {
+		e.sources = make(map[string]ExportSource)
+	}
+	if _, exists := e.sources[name]; exists {
+		panic("export source registered twice: " + name)
+	}
+	e.names = append(e.names, name)
+	e.sources[name] = source
+}
+
+// Build loads every source for the user and returns the zip archive.
+func (e *Exporter) Build(userID int64) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	for _, name := range e.names {
+		value, err := e.sources[name](userID)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", name, err)
+		}
+
+		js, err := json.MarshalIndent(value, "", "\t")
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", name, err)
+		}
+
+		f, err := archive.Create(name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(js)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportModel stores users' data export archives. Each user has at most one;
+// starting a new export replaces the last.
+type ExportModel struct {
+	DB *sql.DB
+}
+
+// Start marks a new export as pending, replacing any earlier archive. It
+// returns ErrExportInProgress if one is already being built.
+func (m *ExportModel) Start(userID int64) error {
+	query := `
+		INSERT INTO data_exports (user_id, status, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET created_at = NOW(), status = EXCLUDED.status, archive = NULL, expiry = EXCLUDED.expiry
+		WHERE data_exports.status <> $2 OR data_exports.created_at < $4`
+	now := time.Now()
+	args := []interface{}{userID, ExportPending, now.Add(exportStaleAfter), now.Add(-exportStaleAfter)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrExportInProgress
+	}
+	return nil
+}
+
+// Complete stores the finished archive, which is deleted after expiry.
+func (m *ExportModel) Complete(userID int64, archive []byte, expiry time.Time) error {
+	query := `
+		UPDATE data_exports
+		SET status = $1, archive = $2, expiry = $3
+		WHERE user_id = $4`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, ExportReady, archive, expiry, userID)
+	return err
+}
+
+// Fail marks the user's pending export as failed, so they can start another.
+func (m *ExportModel) Fail(userID int64) error {
+	query := `UPDATE data_exports SET status = $1 WHERE user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, ExportFailed, userID)
+	return err
+}
+
+// GetArchive returns the user's finished, unexpired archive and when it was
+// started.
+func (m *ExportModel) GetArchive(userID int64) ([]byte, time.Time, error) {
+	query := `
+		SELECT archive, created_at
+		FROM data_exports
+		WHERE user_id = $1 AND status = $2 AND expiry > NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var archive []byte
+	var createdAt time.Time
+	err := m.DB.QueryRowContext(ctx, query, userID, ExportReady).Scan(&archive, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, time.Time{}, ErrRecordNotFound
+		}
+		return nil, time.Time{}, err
+	}
+	return archive, createdAt, nil
+}
+
+// DeleteExpired removes expired archives, along with exports that failed or
+// went stale.
+func (m *ExportModel) DeleteExpired() error {
+	query := `DELETE FROM data_exports WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readArchive returns the files in a zip archive by name.
+func readArchive(t *testing.T, archive []byte) map[string][]byte {
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		contents, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		files[f.Name] = contents
+	}
+	return files
+}
+
+func TestExporter(t *testing.T) {
+	t.Run("One JSON file per source", func(t *testing.T) {
+		e := &Exporter{}
+		e.Register("profile", func(userID int64) (any, error) {
+			return map[string]int64{"id": userID}, nil
+		})
+		e.Register("moods", func(userID int64) (any, error) {
+			return []string{"happy", "calm"}, nil
+		})
+
+		archive, err := e.Build(7)
+		assert.NoError(t, err)
+
+		files := readArchive(t, archive)
+		assert.Len(t, files, 2)
+
+		var profile map[string]int64
+		assert.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+		assert.Equal(t, int64(7), profile["id"])
+
+		var moods []string
+		assert.NoError(t, json.Unmarshal(files["moods.json"], &moods))
+		assert.Equal(t, []string{"happy", "calm"}, moods)
+	})
+
+	t.Run("Source errors fail the export", func(t *testing.T) {
+		e := &Exporter{}
+		e.Register("broken", func(userID int64) (any, error) {
+			return nil, errors.New("boom")
+		})
+		_, err := e.Build(1)
+		assert.ErrorContains(t, err, "export broken")
+	})
+
+	t.Run("Duplicate names panic", func(t *testing.T) {
+		e := &Exporter{}
+		source := func(userID int64) (any, error) { return nil, nil }
+		e.Register("moods", source)
+		assert.Panics(t, func() { e.Register("moods", source) })
+	})
+}
+
+func TestExportModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Build, store and expire an export", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		models := NewModels(db)
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = models.Users.Insert(user)
+		mood := &Mood{Title: "Day", Content: "A good day", Emotion: "happy", Emoji: "😀", Color: "yellow", UserID: user.ID}
+		_ = models.Moods.Insert(mood)
+		_, _ = models.Tokens.New(user.ID, time.Hour, ScopeAuthentication)
+		_ = models.Identities.Insert(&Identity{UserID: user.ID, Issuer: "https://accounts.example.com", Subject: "abc", Email: user.Email})
+		_ = models.SecurityEvents.Insert(&SecurityEvent{UserID: user.ID, Event: EventLoginSucceeded, IPAddress: "192.0.2.1", UserAgent: "test", RequestID: "req"})
+
+		assert.NoError(t, models.Exports.Start(user.ID))
+		assert.ErrorIs(t, models.Exports.Start(user.ID), ErrExportInProgress)
+
+		_, _, err := models.Exports.GetArchive(user.ID)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		archive, err := NewExporter(models).Build(user.ID)
+		assert.NoError(t, err)
+
+		files := readArchive(t, archive)
+		for _, name := range []string{"profile.json", "account.json", "moods.json", "tokens.json", "identities.json", "security_events.json"} {
+			assert.Contains(t, files, name)
+		}
+		assert.Contains(t, string(files["moods.json"]), "A good day")
+		assert.Contains(t, string(files["tokens.json"]), ScopeAuthentication)
+		assert.Contains(t, string(files["identities.json"]), "https://accounts.example.com")
+		assert.Contains(t, string(files["security_events.json"]), EventLoginSucceeded)
+
+		assert.NoError(t, models.Exports.Complete(user.ID, archive, time.Now().Add(time.Hour)))
+		stored, _, err := models.Exports.GetArchive(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, archive, stored)
+
+		// A finished export can be replaced by a new one.
+		assert.NoError(t, models.Exports.Start(user.ID))
+		assert.NoError(t, models.Exports.Fail(user.ID))
+		assert.NoError(t, models.Exports.Start(user.ID))
+
+		_, err = db.Exec(`UPDATE data_exports SET expiry = NOW() - INTERVAL '1 minute'`)
+		assert.NoError(t, err)
+		assert.NoError(t, models.Exports.DeleteExpired())
+		assert.NoError(t, models.Exports.Start(user.ID))
+	})
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"feel-flow-api/internal/validator"
+	"strings"
+	"time"
+)
+
+// GuestTokenPrefix marks a bearer token as a guest account's device token
+// rather than a login token.
+const GuestTokenPrefix = "ffg_"
+
+// GuestTokenTTL is how long a guest token lasts. Guests that stop using the
+// app are purged long before it runs out.
+const GuestTokenTTL = 365 * 24 * time.Hour
+
+// guestEmailDomain is used for the placeholder addresses guest accounts hold
+// until they register. The .invalid top-level domain can never receive mail.
+const guestEmailDomain = "@guest.invalid"
+
+// NewGuest returns an anonymous account to insert with UserModel.Insert.
+// It has a placeholder email address and no password, so it can only be
+// used with its guest token.
+func NewGuest() *User {
+	return &User{
+		Name:  "Guest",
+		Email: "guest-" + strings.ToLower(rand.Text()) + guestEmailDomain,
+		Guest: true,
+		Password: password{
+			hash: []byte{},
+		},
+	}
+}
+
+// ValidateGuestTokenPlaintext checks the shape of a guest token.
+func ValidateGuestTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(strings.HasPrefix(tokenPlaintext, GuestTokenPrefix), "guest_token", "must start with "+GuestTokenPrefix)
+	v.Check(len(tokenPlaintext) == len(GuestTokenPrefix)+26, "guest_token", "must be 30 bytes long")
+}
+
+// NewGuest creates the device token for a guest account. Only its hash is
+// stored, so the returned plaintext must be handed to the device straight
+// away.
+func (m *TokenModel) NewGuest(userID int64) (*Token, error) {
+	token, err := generateToken(userID, GuestTokenTTL, ScopeGuest)
+	if err != nil {
+		return nil, err
+	}
+	token.Plaintext = GuestTokenPrefix + token.Plaintext
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// GetForGuestToken looks up the unconverted guest account a guest token
+// belongs to, and records that it was seen so it isn't purged as inactive.
+func (m *UserModel) GetForGuestToken(tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE users
+		SET last_seen_at = NOW()
+		FROM tokens
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > $3
+		AND users.id = tokens.user_id
+		AND users.guest = true
+		AND users.locked = false
+		RETURNING users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.guest, users.version`
+
+	args := []interface{}{tokenHash[:], ScopeGuest, time.Now()}
+
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Guest,
+		&user.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteInactiveGuests deletes guest accounts that haven't been used for
+// longer than age, along with their moods, and returns how many were
+// deleted.
+func (m *UserModel) DeleteInactiveGuests(age time.Duration) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE guest = true AND COALESCE(last_seen_at, created_at) < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
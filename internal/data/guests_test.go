@@ -0,0 +1,67 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuests_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("NewGuest, GetForGuestToken and conversion", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		tokenModel := TokenModel{DB: db}
+
+		guest := NewGuest()
+		assert.NoError(t, userModel.Insert(guest))
+
+		token, err := tokenModel.NewGuest(guest.ID)
+		assert.NoError(t, err)
+
+		found, err := userModel.GetForGuestToken(token.Plaintext)
+		assert.NoError(t, err)
+		assert.Equal(t, guest.ID, found.ID)
+		assert.True(t, found.Guest)
+
+		// Once converted, the account can no longer be used as a guest.
+		found.Guest = false
+		found.Email = "converted@example.com"
+		_ = found.Password.Set("pa55word-converted")
+		assert.NoError(t, userModel.Update(found))
+
+		_, err = userModel.GetForGuestToken(token.Plaintext)
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+
+	t.Run("DeleteInactiveGuests", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+
+		guest := NewGuest()
+		assert.NoError(t, userModel.Insert(guest))
+		user := &User{Name: "Registered", Email: "registered@example.com"}
+		_ = user.Password.Set("password123")
+		assert.NoError(t, userModel.Insert(user))
+
+		_, err := db.Exec(`UPDATE users SET created_at = NOW() - INTERVAL '40 days'`)
+		assert.NoError(t, err)
+
+		deleted, err := userModel.DeleteInactiveGuests(30 * 24 * time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		_, err = userModel.Get(guest.ID)
+		assert.Equal(t, ErrRecordNotFound, err)
+		_, err = userModel.Get(user.ID)
+		assert.NoError(t, err)
+	})
+}
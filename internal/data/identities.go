@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCStateTTL is how long a user has to sign in at the identity provider.
+const OIDCStateTTL = 10 * time.Minute
+
+// Identity links an account at an OpenID Connect provider to a user. The
+// issuer and subject identify the account; the email address is the one
+// the provider reported when the link was made.
+type Identity struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"-"`
+	Email     string    `json:"email"`
+}
+
+// IdentityModel manages the links between users and external identities.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert links identity to its user. Linking an identity that is already
+// linked is a no-op.
+func (m *IdentityModel) Insert(identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, identity.UserID, identity.Issuer, identity.Subject, identity.Email)
+	return err
+}
+
+// GetUser returns the user an external identity is linked to.
+func (m *IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.locked, users.version
+		FROM users
+		INNER JOIN user_identities ON user_identities.user_id = users.id
+		WHERE user_identities.issuer = $1 AND user_identities.subject = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Locked,
+		&user.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetAllForUser returns the external identities linked to the user.
+func (m *IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+		SELECT id, created_at, user_id, issuer, subject, email
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.ID, &identity.CreatedAt, &identity.UserID, &identity.Issuer, &identity.Subject, &identity.Email)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
+
+// OIDCStateModel stores the state and nonce of sign-ins in progress, so the
+// callback can be finished by any API instance. Only hashes of the states
+// are stored.
+type OIDCStateModel struct {
+	DB *sql.DB
+}
+
+func (m *OIDCStateModel) Insert(state, nonce string) error {
+	query := `
+		INSERT INTO oidc_states (state_hash, nonce, expiry)
+		VALUES ($1, $2, $3)`
+	hash := sha256.Sum256([]byte(state))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash[:], nonce, time.Now().Add(OIDCStateTTL))
+	return err
+}
+
+// Consume deletes an unexpired state so it can only be used once, and
+// returns its nonce. It returns ErrRecordNotFound if there is no such state.
+func (m *OIDCStateModel) Consume(state string) (string, error) {
+	query := `
+		DELETE FROM oidc_states
+		WHERE state_hash = $1 AND expiry > NOW()
+		RETURNING nonce`
+	hash := sha256.Sum256([]byte(state))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var nonce string
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&nonce)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrRecordNotFound
+		}
+		return "", err
+	}
+	return nonce, nil
+}
+
+// DeleteExpired removes states for sign-ins that were never finished.
+func (m *OIDCStateModel) DeleteExpired() error {
+	query := `DELETE FROM oidc_states WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Insert and GetUser", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		identityModel := IdentityModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		_, err := identityModel.GetUser("https://accounts.example.com", "subject-1")
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		identity := &Identity{UserID: user.ID, Issuer: "https://accounts.example.com", Subject: "subject-1", Email: user.Email}
+		assert.NoError(t, identityModel.Insert(identity))
+		// Linking the same identity again is harmless.
+		assert.NoError(t, identityModel.Insert(identity))
+
+		found, err := identityModel.GetUser("https://accounts.example.com", "subject-1")
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, found.ID)
+
+		// The subject is only unique within its issuer.
+		_, err = identityModel.GetUser("https://other.example.com", "subject-1")
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+
+	t.Run("OIDC states are single use", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		stateModel := OIDCStateModel{DB: db}
+		assert.NoError(t, stateModel.Insert("the-state", "the-nonce"))
+
+		nonce, err := stateModel.Consume("the-state")
+		assert.NoError(t, err)
+		assert.Equal(t, "the-nonce", nonce)
+
+		_, err = stateModel.Consume("the-state")
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+}
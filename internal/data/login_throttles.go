@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LockoutPolicy decides how long a login key is locked after repeated failures.
+type LockoutPolicy struct {
+	Threshold int           // Number of failures that triggers the first lockout.
+	BaseDelay time.Duration // Length of the first lockout; each further failure doubles it.
+	MaxDelay  time.Duration // Upper bound on a single lockout.
+	Window    time.Duration // Failures older than this are forgotten.
+}
+
+// Delay returns how long to lock a key that has failed failures times.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// AccountThrottleKey and IPThrottleKey build the keys failures are counted
+// under, so one account can't be attacked from many addresses and one
+// address can't attack many accounts.
+func AccountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginThrottleModel tracks failed login attempts in the database so limits
+// hold across every instance of the API.
+type LoginThrottleModel struct {
+	DB *sql.DB
+}
+
+// LockedFor returns how much longer the most restricted of keys is locked
+// for, or zero if none are.
+func (m *LoginThrottleModel) LockedFor(keys ...string) (time.Duration, error) {
+	query := `
+		SELECT MAX(locked_until)
+		FROM login_throttles
+		WHERE key = ANY($1) AND locked_until > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lockedUntil sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&lockedUntil)
+	if err != nil {
+		return 0, err
+	}
+	if !lockedUntil.Valid {
+		return 0, nil
+	}
+	return time.Until(lockedUntil.Time), nil
+}
+
+// RecordFailure counts a failed attempt against key and locks it according
+// to policy. It returns the new failure count and the lockout applied.
+func (m *LoginThrottleModel) RecordFailure(key string, policy LockoutPolicy) (int, time.Duration, error) {
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_throttles.last_failure_at < $2 THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now().Add(-policy.Window)).Scan(&failures)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	delay := policy.Delay(failures)
+	if delay == 0 {
+		return failures, 0, nil
+	}
+
+	query = `
+		UPDATE login_throttles
+		SET locked_until = $2
+		WHERE key = $1`
+	_, err = m.DB.ExecContext(ctx, query, key, time.Now().Add(delay))
+	if err != nil {
+		return 0, 0, err
+	}
+	return failures, delay, nil
+}
+
+// Reset forgets the failures recorded against key after a successful login.
+func (m *LoginThrottleModel) Reset(key string) error {
+	query := `DELETE FROM login_throttles WHERE key = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -0,0 +1,20 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutPolicy_Delay(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 5, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, Window: time.Hour}
+
+	assert.Equal(t, time.Duration(0), policy.Delay(1))
+	assert.Equal(t, time.Duration(0), policy.Delay(4))
+	assert.Equal(t, time.Minute, policy.Delay(5))
+	assert.Equal(t, 2*time.Minute, policy.Delay(6))
+	assert.Equal(t, 8*time.Minute, policy.Delay(8))
+	assert.Equal(t, 10*time.Minute, policy.Delay(9))
+	assert.Equal(t, 10*time.Minute, policy.Delay(1000))
+}
@@ -0,0 +1,331 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"feel-flow-api/internal/validator"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets when enabling 2FA.
+const RecoveryCodeCount = 10
+
+// TOTPCredential is a user's authenticator app secret. It is unconfirmed
+// until the user proves their app works by entering a first code.
+type TOTPCredential struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// TOTPKeySize is the length of the key TOTP secrets are encrypted with.
+const TOTPKeySize = 32
+
+// errNoTOTPKey is returned when a TOTP secret is stored or read before
+// SetTOTPKey has been called.
+var errNoTOTPKey = errors.New("totp: no encryption key set")
+
+// totpCipher encrypts TOTP secrets with AES-256-GCM, so reading the
+// totp_credentials table alone doesn't reveal anyone's second factor.
+var totpCipher cipher.AEAD
+
+// SetTOTPKey sets the key TOTP secrets are encrypted with. Call it once at
+// startup, before any secrets are stored or read. Changing the key makes
+// existing secrets unreadable, so their users must enroll again.
+func SetTOTPKey(key []byte) error {
+	if len(key) != TOTPKeySize {
+		return errors.New("totp: key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	totpCipher = aead
+	return nil
+}
+
+// sealTOTPSecret encrypts secret for the user. The nonce is stored in front
+// of the ciphertext, and the user ID is authenticated with it, so a secret
+// copied to another user's row won't decrypt.
+func sealTOTPSecret(userID int64, secret []byte) ([]byte, error) {
+	if totpCipher == nil {
+		return nil, errNoTOTPKey
+	}
+	nonce := make([]byte, totpCipher.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return totpCipher.Seal(nonce, nonce, secret, totpAdditionalData(userID)), nil
+}
+
+// openTOTPSecret decrypts a secret sealed by sealTOTPSecret.
+func openTOTPSecret(userID int64, sealed []byte) ([]byte, error) {
+	if totpCipher == nil {
+		return nil, errNoTOTPKey
+	}
+	nonceSize := totpCipher.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("totp: stored secret is too short")
+	}
+	return totpCipher.Open(nil, sealed[:nonceSize], sealed[nonceSize:], totpAdditionalData(userID))
+}
+
+func totpAdditionalData(userID int64) []byte {
+	return []byte("totp:" + strconv.FormatInt(userID, 10))
+}
+
+// TOTPModel manages TOTP secrets in the database. Secrets are stored
+// encrypted with the key set by SetTOTPKey.
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// GetForUser returns the user's TOTP credential, confirmed or not.
+func (m *TOTPModel) GetForUser(userID int64) (*TOTPCredential, error) {
+	query := `
+		SELECT user_id, created_at, secret, confirmed, last_used_step
+		FROM totp_credentials
+		WHERE user_id = $1`
+
+	var cred TOTPCredential
+	var sealed []byte
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&cred.UserID,
+		&cred.CreatedAt,
+		&sealed,
+		&cred.Confirmed,
+		&cred.LastUsedStep,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	cred.Secret, err = openTOTPSecret(cred.UserID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// Enabled reports whether the user has confirmed TOTP two-factor
+// authentication. It doesn't decrypt the secret, so users can still sign in
+// with a recovery code if it can't be read.
+func (m *TOTPModel) Enabled(userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM totp_credentials
+			WHERE user_id = $1 AND confirmed = TRUE
+		)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// InsertPending stores a new unconfirmed secret, replacing any earlier
+// enrollment that was never confirmed.
+func (m *TOTPModel) InsertPending(userID int64, secret []byte) error {
+	query := `
+		INSERT INTO totp_credentials (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE totp_credentials.confirmed = FALSE`
+	sealed, err := sealTOTPSecret(userID, secret)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, sealed)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Confirm enables the user's pending secret.
+func (m *TOTPModel) Confirm(userID int64) error {
+	query := `
+		UPDATE totp_credentials
+		SET confirmed = TRUE
+		WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// UseStep records that the code for step was used. It fails with
+// ErrEditConflict if that step (or a later one) was already used, so a code
+// can't be replayed within its validity window.
+func (m *TOTPModel) UseStep(userID, step int64) error {
+	query := `
+		UPDATE totp_credentials
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Delete turns off TOTP for the user and discards their recovery codes.
+func (m *TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_credentials WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// normalizeRecoveryCode lets users type codes with or without the dash and in any case.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hash[:]
+}
+
+func ValidateRecoveryCode(v *validator.Validator, code string) {
+	v.Check(code != "", "recovery_code", "must be provided")
+	v.Check(len(normalizeRecoveryCode(code)) == 10, "recovery_code", "must be 10 characters long")
+}
+
+// RecoveryCodeModel manages single-use 2FA recovery codes. Only hashes are stored.
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// Replace discards the user's existing recovery codes and returns a fresh set
+// in "xxxxx-xxxxx" form. The plaintext codes are never available again.
+func (m *RecoveryCodeModel) Replace(userID int64) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(randomBytes)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hashRecoveryCode(code), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// Use marks an unused recovery code as used. It returns ErrRecordNotFound if
+// the code is wrong or has already been used.
+func (m *RecoveryCodeModel) Use(userID int64, code string) error {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountUnused returns how many recovery codes the user has left.
+func (m *RecoveryCodeModel) CountUnused(userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
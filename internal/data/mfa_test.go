@@ -0,0 +1,46 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPSecretEncryption(t *testing.T) {
+	saved := totpCipher
+	defer func() { totpCipher = saved }()
+
+	secret := []byte("12345678901234567890")
+
+	t.Run("No key", func(t *testing.T) {
+		totpCipher = nil
+		_, err := sealTOTPSecret(1, secret)
+		assert.ErrorIs(t, err, errNoTOTPKey)
+	})
+
+	t.Run("Key size", func(t *testing.T) {
+		assert.Error(t, SetTOTPKey(make([]byte, 16)))
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		assert.NoError(t, SetTOTPKey(bytes.Repeat([]byte{1}, TOTPKeySize)))
+
+		sealed, err := sealTOTPSecret(1, secret)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(sealed), string(secret))
+
+		opened, err := openTOTPSecret(1, sealed)
+		assert.NoError(t, err)
+		assert.Equal(t, secret, opened)
+
+		// A secret only decrypts for the user it was stored for.
+		_, err = openTOTPSecret(2, sealed)
+		assert.Error(t, err)
+
+		// Nor with another key.
+		assert.NoError(t, SetTOTPKey(bytes.Repeat([]byte{2}, TOTPKeySize)))
+		_, err = openTOTPSecret(1, sealed)
+		assert.Error(t, err)
+	})
+}
@@ -6,6 +6,27 @@ type Models struct {
     Moods MoodModel
     Users UserModel
     Tokens TokenModel
+    DenyList DenyListModel
+    APIKeys APIKeyModel
+    TOTP TOTPModel
+    RecoveryCodes RecoveryCodeModel
+    LoginThrottles LoginThrottleModel
+    Passkeys PasskeyModel
+    WebAuthnChallenges WebAuthnChallengeModel
+    OAuthClients OAuthClientModel
+    OAuthGrants OAuthGrantModel
+    OAuthCodes OAuthCodeModel
+    OAuthTokens OAuthTokenModel
+    Identities IdentityModel
+    OIDCStates OIDCStateModel
+    Permissions PermissionModel
+    AdminActions AdminActionModel
+    SecurityEvents SecurityEventModel
+    Exports ExportModel
+    Settings UserSettingsModel
+    Reminders ReminderModel
+    Digests DigestModel
+    Emails EmailModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -13,5 +34,26 @@ func NewModels(db *sql.DB) Models {
         Moods: MoodModel{DB: db},
         Users: UserModel{DB: db},
         Tokens: TokenModel{DB: db},
+        DenyList: DenyListModel{DB: db},
+        APIKeys: APIKeyModel{DB: db},
+        TOTP: TOTPModel{DB: db},
+        RecoveryCodes: RecoveryCodeModel{DB: db},
+        LoginThrottles: LoginThrottleModel{DB: db},
+        Passkeys: PasskeyModel{DB: db},
+        WebAuthnChallenges: WebAuthnChallengeModel{DB: db},
+        OAuthClients: OAuthClientModel{DB: db},
+        OAuthGrants: OAuthGrantModel{DB: db},
+        OAuthCodes: OAuthCodeModel{DB: db},
+        OAuthTokens: OAuthTokenModel{DB: db},
+        Identities: IdentityModel{DB: db},
+        OIDCStates: OIDCStateModel{DB: db},
+        Permissions: PermissionModel{DB: db},
+        AdminActions: AdminActionModel{DB: db},
+        SecurityEvents: SecurityEventModel{DB: db},
+        Exports: ExportModel{DB: db},
+        Settings: UserSettingsModel{DB: db},
+        Reminders: ReminderModel{DB: db},
+        Digests: DigestModel{DB: db},
+        Emails: EmailModel{DB: db},
     }
 }
\ No newline at end of file
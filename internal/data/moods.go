@@ -148,6 +148,107 @@ func (m MoodModel) GetAll(title string, emotion string, userID int64, filters Fi
     return moods, metadata, nil
 }
 
+// GetAllForUser returns every mood the user has recorded, oldest first.
+func (m MoodModel) GetAllForUser(userID int64) ([]*Mood, error) {
+	query := `
+		SELECT id, created_at, updated_at, title, content, emotion, emoji, color
+		FROM moods
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	moods := []*Mood{}
+	for rows.Next() {
+		mood := Mood{UserID: userID}
+		err := rows.Scan(&mood.ID, &mood.CreatedAt, &mood.UpdatedAt, &mood.Title, &mood.Content, &mood.Emotion, &mood.Emoji, &mood.Color)
+		if err != nil {
+			return nil, err
+		}
+		moods = append(moods, &mood)
+	}
+	return moods, rows.Err()
+}
+
+// MoodSummary describes a user's journal without revealing its content.
+type MoodSummary struct {
+	TotalMoods    int64      `json:"total_moods"`
+	FirstEntryAt  *time.Time `json:"first_entry_at"`
+	CurrentStreak int        `json:"current_streak"`
+}
+
+// GetSummary counts the user's moods and works out their current streak:
+// the number of consecutive days, in loc, with at least one mood, ending
+// today or yesterday.
+func (m MoodModel) GetSummary(userID int64, loc *time.Location, now time.Time) (*MoodSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var summary MoodSummary
+	query := `SELECT COUNT(*), MIN(created_at) FROM moods WHERE user_id = $1`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&summary.TotalMoods, &summary.FirstEntryAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT DISTINCT (created_at AT TIME ZONE $2)::date AS day
+		FROM moods
+		WHERE user_id = $1
+		ORDER BY day DESC`
+	rows, err := m.DB.QueryContext(ctx, query, userID, loc.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []time.Time{}
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	summary.CurrentStreak = currentStreak(days, now.In(loc))
+	return &summary, nil
+}
+
+// currentStreak counts the consecutive days at the start of days, which
+// must be distinct dates, newest first, that end today or yesterday.
+func currentStreak(days []time.Time, today time.Time) int {
+	expected := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if len(days) > 0 && sameDate(days[0], expected.AddDate(0, 0, -1)) {
+		// Today's mood may not have been logged yet.
+		expected = expected.AddDate(0, 0, -1)
+	}
+
+	streak := 0
+	for _, day := range days {
+		if !sameDate(day, expected) {
+			break
+		}
+		streak++
+		expected = expected.AddDate(0, 0, -1)
+	}
+	return streak
+}
+
+func sameDate(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.Month() == b.Month() && a.Day() == b.Day()
+}
+
 func (m MoodModel) Update(mood *Mood) error {
 	query := `
 		UPDATE moods
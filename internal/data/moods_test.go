@@ -115,4 +115,45 @@ func TestMoodModel_Integration(t *testing.T) {
 		assert.Equal(t, ErrRecordNotFound, err)
 		assert.Nil(t, deletedMood)
 	})
+
+	t.Run("GetSummary", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		moodModel := MoodModel{DB: db}
+		userModel := UserModel{DB: db}
+		user := &User{Name: "Streaker", Email: "streak@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		summary, err := moodModel.GetSummary(user.ID, time.UTC, time.Now())
+		assert.NoError(t, err)
+		assert.Zero(t, summary.TotalMoods)
+		assert.Nil(t, summary.FirstEntryAt)
+		assert.Zero(t, summary.CurrentStreak)
+
+		for range 2 {
+			mood := &Mood{Title: "Today", Content: "...", Emotion: "Calm", Emoji: "🙂", Color: "#AAAAAA", UserID: user.ID}
+			assert.NoError(t, moodModel.Insert(mood))
+		}
+
+		summary, err = moodModel.GetSummary(user.ID, time.UTC, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), summary.TotalMoods)
+		assert.NotNil(t, summary.FirstEntryAt)
+		assert.Equal(t, 1, summary.CurrentStreak)
+	})
+}
+
+func TestCurrentStreak(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2025, time.March, day, 0, 0, 0, 0, time.UTC) }
+	today := time.Date(2025, time.March, 10, 21, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, 0, currentStreak(nil, today))
+	assert.Equal(t, 3, currentStreak([]time.Time{date(10), date(9), date(8), date(6)}, today))
+	// The streak is still alive if today's mood hasn't been logged yet.
+	assert.Equal(t, 2, currentStreak([]time.Time{date(9), date(8), date(5)}, today))
+	assert.Equal(t, 0, currentStreak([]time.Time{date(8), date(7)}, today))
+	// Streaks carry across month ends.
+	assert.Equal(t, 3, currentStreak([]time.Time{date(1), time.Date(2025, time.February, 28, 0, 0, 0, 0, time.UTC), time.Date(2025, time.February, 27, 0, 0, 0, 0, time.UTC)}, time.Date(2025, time.March, 1, 8, 0, 0, 0, time.UTC)))
 }
\ No newline at end of file
@@ -0,0 +1,557 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"feel-flow-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Prefixes that identify OAuth client IDs and tokens at a glance.
+const (
+	OAuthClientIDPrefix     = "ffc_"
+	OAuthAccessTokenPrefix  = "ffo_"
+	OAuthRefreshTokenPrefix = "ffr_"
+)
+
+// Lifetimes of the credentials issued during the authorization code flow.
+const (
+	OAuthCodeTTL         = 10 * time.Minute
+	OAuthAccessTokenTTL  = time.Hour
+	OAuthRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// OAuthScopes lists every scope a third-party app may ask for. They are the
+// same scopes API keys use, so requireScope enforces both.
+var OAuthScopes = APIKeyScopes
+
+// oauthSecret returns prefix followed by n random bytes in lowercase base32.
+func oauthSecret(prefix string, n int) (string, error) {
+	randomBytes := make([]byte, n)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return prefix + apiKeyEncoding.EncodeToString(randomBytes), nil
+}
+
+func oauthHash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// ParseOAuthScope splits a space-separated scope parameter, dropping duplicates.
+func ParseOAuthScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func ValidateOAuthScopes(v *validator.Validator, scopes []string) {
+	v.Check(len(scopes) > 0, "scope", "must contain at least one scope")
+	for _, scope := range scopes {
+		v.Check(validator.PermittedValue(scope, OAuthScopes...), "scope", "must only contain "+strings.Join(OAuthScopes, ", "))
+	}
+}
+
+// OAuthClient is a third-party application registered to use the OAuth2
+// authorization code flow. Public clients (mobile and browser apps) have no
+// secret and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	Secret       string    `json:"client_secret,omitempty"` // Only set when the client is first registered.
+	SecretHash   []byte    `json:"-"`
+	UserID       int64     `json:"-"`
+}
+
+// HasRedirectURI reports whether uri exactly matches a registered redirect URI.
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// SecretMatches reports whether secret is the client's secret. It is always
+// false for public clients.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if !c.Confidential || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare(oauthHash(secret), c.SecretHash) == 1
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(client.RedirectURIs) > 0, "redirect_uris", "must contain at least one URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must be https URLs, http URLs on a loopback address, or app URLs like com.example.app:/callback, without a fragment")
+	}
+}
+
+// validRedirectURI follows RFC 8252: plain http is only allowed for loopback
+// redirects, and native apps may use a private-use scheme in reverse domain form.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || len(uri) > 2048 {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		return host == "localhost" || (ip != nil && ip.IsLoopback())
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+var pkceRX = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// ValidatePKCEChallenge checks the code_challenge sent to the authorize
+// endpoint. Only the S256 method is accepted.
+func ValidatePKCEChallenge(v *validator.Validator, challenge, method string) {
+	v.Check(method == "S256", "code_challenge_method", "must be S256")
+	v.Check(len(challenge) == 43 && validator.Matches(challenge, pkceRX), "code_challenge", "must be a base64url encoded SHA-256 hash")
+}
+
+// PKCEMatches reports whether verifier hashes to challenge (RFC 7636, S256).
+func PKCEMatches(verifier, challenge string) bool {
+	if !validator.Matches(verifier, pkceRX) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// OAuthClientModel manages registered OAuth clients.
+type OAuthClientModel struct {
+	DB *sql.DB
+}
+
+// New registers a client. Confidential clients get a secret, which is
+// returned in the client and never available again.
+func (m *OAuthClientModel) New(client *OAuthClient) error {
+	var err error
+	client.ID, err = oauthSecret(OAuthClientIDPrefix, 10)
+	if err != nil {
+		return err
+	}
+	if client.Confidential {
+		client.Secret, err = oauthSecret("", 32)
+		if err != nil {
+			return err
+		}
+		client.SecretHash = oauthHash(client.Secret)
+	}
+
+	query := `
+		INSERT INTO oauth_clients (id, user_id, name, redirect_uris, confidential, secret_hash)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+	args := []interface{}{client.ID, client.UserID, client.Name, pq.Array(client.RedirectURIs), client.Confidential, client.SecretHash}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.CreatedAt)
+}
+
+const oauthClientColumns = `id, created_at, name, redirect_uris, confidential, secret_hash, user_id`
+
+func scanOAuthClient(row interface{ Scan(...any) error }) (*OAuthClient, error) {
+	var client OAuthClient
+	err := row.Scan(
+		&client.ID,
+		&client.CreatedAt,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		&client.Confidential,
+		&client.SecretHash,
+		&client.UserID,
+	)
+	return &client, err
+}
+
+// Get returns the client with the given client ID.
+func (m *OAuthClientModel) Get(id string) (*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	client, err := scanOAuthClient(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// GetAllForUser returns the clients the user has registered.
+func (m *OAuthClientModel) GetAllForUser(userID int64) ([]*OAuthClient, error) {
+	query := `
+		SELECT ` + oauthClientColumns + `
+		FROM oauth_clients
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// Delete removes a client the user registered, along with every grant, code
+// and token issued to it.
+func (m *OAuthClientModel) Delete(id string, userID int64) error {
+	query := `DELETE FROM oauth_clients WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// OAuthGrant records that a user consented to give a client some scopes.
+type OAuthGrant struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// OAuthGrantModel manages users' consent to OAuth clients.
+type OAuthGrantModel struct {
+	DB *sql.DB
+}
+
+// Upsert records consent to scopes, adding to any scopes granted before.
+func (m *OAuthGrantModel) Upsert(userID int64, clientID string, scopes []string) error {
+	query := `
+		INSERT INTO oauth_grants (user_id, client_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE
+		SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_grants.scopes || EXCLUDED.scopes) ORDER BY 1),
+			updated_at = NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, clientID, pq.Array(scopes))
+	return err
+}
+
+// GetAllForUser lists the apps the user has given access to.
+func (m *OAuthGrantModel) GetAllForUser(userID int64) ([]*OAuthGrant, error) {
+	query := `
+		SELECT oauth_grants.client_id, oauth_clients.name, oauth_grants.scopes, oauth_grants.created_at, oauth_grants.updated_at
+		FROM oauth_grants
+		INNER JOIN oauth_clients ON oauth_clients.id = oauth_grants.client_id
+		WHERE oauth_grants.user_id = $1
+		ORDER BY oauth_grants.updated_at DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []*OAuthGrant{}
+	for rows.Next() {
+		var grant OAuthGrant
+		err := rows.Scan(&grant.ClientID, &grant.ClientName, pq.Array(&grant.Scopes), &grant.CreatedAt, &grant.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, &grant)
+	}
+	return grants, rows.Err()
+}
+
+// Delete withdraws the user's consent to a client and revokes every code
+// and token the client holds for them.
+func (m *OAuthGrantModel) Delete(userID int64, clientID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM oauth_grants WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	for _, table := range []string{"oauth_codes", "oauth_tokens"} {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// OAuthCode is a single-use authorization code issued after consent.
+type OAuthCode struct {
+	Plaintext     string
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+// OAuthCodeModel manages authorization codes. Only hashes are stored.
+type OAuthCodeModel struct {
+	DB *sql.DB
+}
+
+// Insert generates the code's plaintext and stores its hash.
+func (m *OAuthCodeModel) Insert(code *OAuthCode) error {
+	var err error
+	code.Plaintext, err = oauthSecret("", 32)
+	if err != nil {
+		return err
+	}
+	code.Expiry = time.Now().Add(OAuthCodeTTL)
+
+	query := `
+		INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	args := []interface{}{oauthHash(code.Plaintext), code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, code.Expiry}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Consume deletes and returns an unexpired code issued to clientID, so it
+// can only be exchanged once.
+func (m *OAuthCodeModel) Consume(plaintext, clientID string) (*OAuthCode, error) {
+	query := `
+		DELETE FROM oauth_codes
+		WHERE hash = $1 AND client_id = $2 AND expiry > NOW()
+		RETURNING user_id, redirect_uri, scopes, code_challenge, expiry`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code := OAuthCode{Plaintext: plaintext, ClientID: clientID}
+	err := m.DB.QueryRowContext(ctx, query, oauthHash(plaintext), clientID).Scan(
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &code, nil
+}
+
+// OAuthTokenPair is the token endpoint's successful response (RFC 6749 section 5.1).
+type OAuthTokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthToken is an access or refresh token held by a client for a user.
+type OAuthToken struct {
+	ClientID string
+	UserID   int64
+	Scopes   []string
+	Expiry   time.Time
+}
+
+// OAuthTokenModel manages access and refresh tokens. Only hashes are stored.
+type OAuthTokenModel struct {
+	DB *sql.DB
+}
+
+// NewPair issues an access token and a refresh token for scopes.
+func (m *OAuthTokenModel) NewPair(clientID string, userID int64, scopes []string) (*OAuthTokenPair, error) {
+	access, err := oauthSecret(OAuthAccessTokenPrefix, 32)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := oauthSecret(OAuthRefreshTokenPrefix, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO oauth_tokens (hash, client_id, user_id, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5), ($6, $2, $3, $4, $7)`
+	now := time.Now()
+	args := []interface{}{
+		oauthHash(access), clientID, userID, pq.Array(scopes), now.Add(OAuthAccessTokenTTL),
+		oauthHash(refresh), now.Add(OAuthRefreshTokenTTL),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthTokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(OAuthAccessTokenTTL.Seconds()),
+		RefreshToken: refresh,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// GetForAccessToken looks up an unexpired access token and its user.
+func (m *OAuthTokenModel) GetForAccessToken(plaintext string) (*OAuthToken, *User, error) {
+	if !strings.HasPrefix(plaintext, OAuthAccessTokenPrefix) {
+		return nil, nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT oauth_tokens.client_id, oauth_tokens.scopes, oauth_tokens.expiry,
+			users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		FROM oauth_tokens
+		INNER JOIN users ON users.id = oauth_tokens.user_id
+		WHERE oauth_tokens.hash = $1 AND oauth_tokens.expiry > NOW() AND users.locked = false`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token OAuthToken
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, oauthHash(plaintext)).Scan(
+		&token.ClientID,
+		pq.Array(&token.Scopes),
+		&token.Expiry,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrRecordNotFound
+		}
+		return nil, nil, err
+	}
+	token.UserID = user.ID
+	return &token, &user, nil
+}
+
+// ConsumeRefreshToken deletes and returns an unexpired refresh token issued
+// to clientID. Refresh tokens are rotated: each can only be used once.
+func (m *OAuthTokenModel) ConsumeRefreshToken(plaintext, clientID string) (*OAuthToken, error) {
+	if !strings.HasPrefix(plaintext, OAuthRefreshTokenPrefix) {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM oauth_tokens
+		WHERE hash = $1 AND client_id = $2 AND expiry > NOW()
+		RETURNING user_id, scopes, expiry`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := OAuthToken{ClientID: clientID}
+	err := m.DB.QueryRowContext(ctx, query, oauthHash(plaintext), clientID).Scan(&token.UserID, pq.Array(&token.Scopes), &token.Expiry)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Revoke deletes an access or refresh token issued to clientID. Revoking an
+// unknown token is not an error (RFC 7009 section 2.2).
+func (m *OAuthTokenModel) Revoke(plaintext, clientID string) error {
+	query := `DELETE FROM oauth_tokens WHERE hash = $1 AND client_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, oauthHash(plaintext), clientID)
+	return err
+}
+
+// DeleteExpired removes codes that were never exchanged.
+func (m *OAuthCodeModel) DeleteExpired() error {
+	query := `DELETE FROM oauth_codes WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
+
+// DeleteExpired removes expired access and refresh tokens.
+func (m *OAuthTokenModel) DeleteExpired() error {
+	query := `DELETE FROM oauth_tokens WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"feel-flow-api/internal/validator"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKCEMatches(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	assert.True(t, PKCEMatches(verifier, challenge))
+	assert.False(t, PKCEMatches(verifier+"x", challenge))
+	assert.False(t, PKCEMatches("too-short", challenge))
+	assert.False(t, PKCEMatches(challenge, challenge))
+
+	v := validator.New()
+	ValidatePKCEChallenge(v, challenge, "S256")
+	assert.True(t, v.IsEmpty())
+
+	v = validator.New()
+	ValidatePKCEChallenge(v, verifier, "plain")
+	assert.Contains(t, v.Errors, "code_challenge_method")
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	for uri, want := range map[string]bool{
+		"https://portal.example.com/callback": true,
+		"http://localhost:8080/callback":      true,
+		"http://127.0.0.1/callback":           true,
+		"com.example.app:/oauth2redirect":     true,
+		"http://portal.example.com/callback":  false,
+		"https://portal.example.com/cb#frag":  false,
+		"/callback":                           false,
+		"javascript:alert(1)":                 false,
+	} {
+		assert.Equal(t, want, validRedirectURI(uri), uri)
+	}
+}
+
+func TestValidateOAuthScopes(t *testing.T) {
+	assert.Equal(t, []string{ScopeMoodsRead, ScopeMoodsWrite}, ParseOAuthScope(" moods:read moods:write moods:read "))
+
+	v := validator.New()
+	ValidateOAuthScopes(v, ParseOAuthScope("moods:read"))
+	assert.True(t, v.IsEmpty())
+
+	v = validator.New()
+	ValidateOAuthScopes(v, ParseOAuthScope("moods:read admin"))
+	assert.Contains(t, v.Errors, "scope")
+
+	v = validator.New()
+	ValidateOAuthScopes(v, nil)
+	assert.Contains(t, v.Errors, "scope")
+}
+
+func TestOAuthModels_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Code exchange, refresh and grant revocation", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		clientModel := OAuthClientModel{DB: db}
+		grantModel := OAuthGrantModel{DB: db}
+		codeModel := OAuthCodeModel{DB: db}
+		tokenModel := OAuthTokenModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		client := &OAuthClient{
+			Name:         "Therapist Portal",
+			RedirectURIs: []string{"https://portal.example.com/callback"},
+			Confidential: true,
+			UserID:       user.ID,
+		}
+		assert.NoError(t, clientModel.New(client))
+		assert.True(t, strings.HasPrefix(client.ID, OAuthClientIDPrefix))
+
+		stored, err := clientModel.Get(client.ID)
+		assert.NoError(t, err)
+		assert.True(t, stored.SecretMatches(client.Secret))
+		assert.False(t, stored.SecretMatches("wrong"))
+
+		scopes := []string{ScopeMoodsRead}
+		assert.NoError(t, grantModel.Upsert(user.ID, client.ID, scopes))
+
+		code := &OAuthCode{ClientID: client.ID, UserID: user.ID, RedirectURI: client.RedirectURIs[0], Scopes: scopes, CodeChallenge: "challenge"}
+		assert.NoError(t, codeModel.Insert(code))
+
+		// Codes are single use.
+		got, err := codeModel.Consume(code.Plaintext, client.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, got.UserID)
+		_, err = codeModel.Consume(code.Plaintext, client.ID)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		pair, err := tokenModel.NewPair(client.ID, user.ID, scopes)
+		assert.NoError(t, err)
+		token, tokenUser, err := tokenModel.GetForAccessToken(pair.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, scopes, token.Scopes)
+		assert.Equal(t, user.ID, tokenUser.ID)
+
+		// Refresh tokens rotate: the old one can't be used again.
+		_, err = tokenModel.ConsumeRefreshToken(pair.RefreshToken, client.ID)
+		assert.NoError(t, err)
+		_, err = tokenModel.ConsumeRefreshToken(pair.RefreshToken, client.ID)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		// Revoking the grant revokes the app's tokens.
+		pair, err = tokenModel.NewPair(client.ID, user.ID, scopes)
+		assert.NoError(t, err)
+		assert.NoError(t, grantModel.Delete(user.ID, client.ID))
+		_, _, err = tokenModel.GetForAccessToken(pair.AccessToken)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+}
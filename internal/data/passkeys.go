@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"feel-flow-api/internal/validator"
+	"feel-flow-api/internal/webauthn"
+
+	"github.com/lib/pq"
+)
+
+// WebAuthn ceremonies a challenge can be issued for.
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// Passkey is a WebAuthn credential registered to a user.
+type Passkey struct {
+	ID         int64               `json:"id"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Name       string              `json:"name"`
+	Transports []string            `json:"transports"`
+	Synced     bool                `json:"synced"`
+	LastUsedAt *time.Time          `json:"last_used_at"`
+	UserID     int64               `json:"-"`
+	Credential webauthn.Credential `json:"-"`
+}
+
+func ValidatePasskeyName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// PasskeyModel manages WebAuthn credentials in the database.
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a newly registered passkey. It returns ErrDuplicatePasskey
+// if the credential is already registered.
+func (m *PasskeyModel) Insert(passkey *Passkey) error {
+	query := `
+		INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, sign_count, transports, aaguid, backup_eligible)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	cred := passkey.Credential
+	if cred.Transports == nil {
+		cred.Transports = []string{}
+	}
+	args := []interface{}{
+		passkey.UserID,
+		passkey.Name,
+		cred.ID,
+		cred.PublicKey,
+		int64(cred.SignCount),
+		pq.Array(cred.Transports),
+		cred.AAGUID,
+		cred.BackupEligible,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&passkey.ID, &passkey.CreatedAt)
+	if err != nil {
+		if err.Error() == `pq: duplicate key value violates unique constraint "webauthn_credentials_credential_id_key"` {
+			return ErrDuplicatePasskey
+		}
+		return err
+	}
+	passkey.Transports = cred.Transports
+	passkey.Synced = cred.BackupEligible
+	return nil
+}
+
+const passkeyColumns = `id, created_at, name, user_id, credential_id, public_key, sign_count, transports, aaguid, backup_eligible, last_used_at`
+
+func scanPasskey(row interface{ Scan(...any) error }) (*Passkey, error) {
+	var passkey Passkey
+	var signCount int64
+	err := row.Scan(
+		&passkey.ID,
+		&passkey.CreatedAt,
+		&passkey.Name,
+		&passkey.UserID,
+		&passkey.Credential.ID,
+		&passkey.Credential.PublicKey,
+		&signCount,
+		pq.Array(&passkey.Credential.Transports),
+		&passkey.Credential.AAGUID,
+		&passkey.Credential.BackupEligible,
+		&passkey.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	passkey.Credential.SignCount = uint32(signCount)
+	passkey.Transports = passkey.Credential.Transports
+	passkey.Synced = passkey.Credential.BackupEligible
+	return &passkey, nil
+}
+
+// GetAllForUser returns the user's passkeys, newest first.
+func (m *PasskeyModel) GetAllForUser(userID int64) ([]*Passkey, error) {
+	query := `
+		SELECT ` + passkeyColumns + `
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+// GetByCredentialID looks up the passkey an authenticator used to sign in.
+func (m *PasskeyModel) GetByCredentialID(credentialID []byte) (*Passkey, error) {
+	query := `
+		SELECT ` + passkeyColumns + `
+		FROM webauthn_credentials
+		WHERE credential_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	passkey, err := scanPasskey(m.DB.QueryRowContext(ctx, query, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// RecordUse saves the authenticator's new signature counter after a login.
+func (m *PasskeyModel) RecordUse(id int64, signCount uint32) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, last_used_at = NOW()
+		WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id, int64(signCount))
+	return err
+}
+
+// Delete removes one of the user's passkeys.
+func (m *PasskeyModel) Delete(id, userID int64) error {
+	query := `
+		DELETE FROM webauthn_credentials
+		WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// WebAuthnChallengeModel stores the challenges of in-progress ceremonies so
+// any API instance can finish a ceremony another one started. Only hashes
+// of the challenges are stored.
+type WebAuthnChallengeModel struct {
+	DB *sql.DB
+}
+
+// Insert records a challenge for ceremony. userID is zero when a login
+// ceremony isn't tied to a particular account.
+func (m *WebAuthnChallengeModel) Insert(challenge []byte, userID int64, ceremony string, ttl time.Duration) error {
+	query := `
+		INSERT INTO webauthn_challenges (challenge_hash, user_id, ceremony, expiry)
+		VALUES ($1, $2, $3, $4)`
+	hash := sha256.Sum256(challenge)
+	user := sql.NullInt64{Int64: userID, Valid: userID != 0}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash[:], user, ceremony, time.Now().Add(ttl))
+	return err
+}
+
+// Consume deletes an unexpired challenge so it can only be used once, and
+// returns the user it was issued to (zero if none). It returns
+// ErrRecordNotFound if there is no such challenge.
+func (m *WebAuthnChallengeModel) Consume(challenge []byte, ceremony string) (int64, error) {
+	query := `
+		DELETE FROM webauthn_challenges
+		WHERE challenge_hash = $1 AND ceremony = $2 AND expiry > NOW()
+		RETURNING user_id`
+	hash := sha256.Sum256(challenge)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID sql.NullInt64
+	err := m.DB.QueryRowContext(ctx, query, hash[:], ceremony).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrRecordNotFound
+		}
+		return 0, err
+	}
+	return userID.Int64, nil
+}
+
+// DeleteExpired removes challenges for ceremonies that were never finished.
+func (m *WebAuthnChallengeModel) DeleteExpired() error {
+	query := `DELETE FROM webauthn_challenges WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
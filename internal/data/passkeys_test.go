@@ -0,0 +1,76 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"feel-flow-api/internal/webauthn"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasskeyModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Insert, GetByCredentialID, RecordUse and Delete", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		passkeyModel := PasskeyModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		passkey := &Passkey{
+			Name:   "Laptop",
+			UserID: user.ID,
+			Credential: webauthn.Credential{
+				ID:         []byte("credential-id"),
+				PublicKey:  []byte("cose-key"),
+				Transports: []string{"internal"},
+			},
+		}
+		assert.NoError(t, passkeyModel.Insert(passkey))
+		assert.NotZero(t, passkey.ID)
+
+		// The same authenticator can't be registered twice.
+		assert.Equal(t, ErrDuplicatePasskey, passkeyModel.Insert(&Passkey{Name: "Again", UserID: user.ID, Credential: passkey.Credential}))
+
+		assert.NoError(t, passkeyModel.RecordUse(passkey.ID, 7))
+		found, err := passkeyModel.GetByCredentialID([]byte("credential-id"))
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, found.UserID)
+		assert.Equal(t, uint32(7), found.Credential.SignCount)
+		assert.Equal(t, []string{"internal"}, found.Transports)
+		assert.NotNil(t, found.LastUsedAt)
+
+		// Another user can't delete the passkey.
+		assert.Equal(t, ErrRecordNotFound, passkeyModel.Delete(passkey.ID, user.ID+1))
+		assert.NoError(t, passkeyModel.Delete(passkey.ID, user.ID))
+	})
+
+	t.Run("Challenges are single use", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		challengeModel := WebAuthnChallengeModel{DB: db}
+		challenge := []byte("a challenge")
+
+		assert.NoError(t, challengeModel.Insert(challenge, 0, CeremonyLogin, time.Minute))
+
+		// The wrong ceremony doesn't consume it.
+		_, err := challengeModel.Consume(challenge, CeremonyRegistration)
+		assert.Equal(t, ErrRecordNotFound, err)
+
+		userID, err := challengeModel.Consume(challenge, CeremonyLogin)
+		assert.NoError(t, err)
+		assert.Zero(t, userID)
+
+		_, err = challengeModel.Consume(challenge, CeremonyLogin)
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permission codes. The mood codes match the API key scopes of the same
+// name, but are checked separately: a scope limits what a credential may do,
+// a permission what the account may do.
+const (
+	PermissionMoodsRead  = "moods:read"
+	PermissionMoodsWrite = "moods:write"
+	PermissionAdminUsers = "admin:users"
+)
+
+// DefaultPermissions are granted to every new account.
+var DefaultPermissions = Permissions{PermissionMoodsRead, PermissionMoodsWrite}
+
+// Permissions holds the permission codes granted to a user.
+type Permissions []string
+
+// Include reports whether code is one of the permissions.
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// PermissionModel manages the permissions granted to users.
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser returns the codes of every permission granted to the user.
+func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var code string
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+	return permissions, rows.Err()
+}
+
+// AddForUser grants the user the given permissions. Granting a permission
+// the user already has is a no-op.
+func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissions_Include(t *testing.T) {
+	assert.True(t, DefaultPermissions.Include(PermissionMoodsRead))
+	assert.True(t, DefaultPermissions.Include(PermissionMoodsWrite))
+	assert.False(t, DefaultPermissions.Include(PermissionAdminUsers))
+	assert.False(t, Permissions{}.Include(PermissionMoodsRead))
+}
+
+func TestPermissionModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("AddForUser and GetAllForUser", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		permissionModel := PermissionModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		permissions, err := permissionModel.GetAllForUser(user.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, permissions)
+
+		assert.NoError(t, permissionModel.AddForUser(user.ID, DefaultPermissions...))
+		// Granting the same permission twice is harmless, and unknown codes are ignored.
+		assert.NoError(t, permissionModel.AddForUser(user.ID, PermissionMoodsRead, "no:such-permission"))
+
+		permissions, err = permissionModel.GetAllForUser(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, Permissions{PermissionMoodsRead, PermissionMoodsWrite}, permissions)
+	})
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DueReminder is a user who should be sent their daily check-in reminder.
+type DueReminder struct {
+	UserID int64
+	Name   string
+	Email  string
+	Locale string
+}
+
+// ReminderModel schedules the daily check-in reminders set up with
+// UserSettings.ReminderTime.
+type ReminderModel struct {
+	DB *sql.DB
+}
+
+// ClaimDue marks up to limit users as reminded today and returns them. A user
+// is due when their local time has passed their reminder time by less than
+// window, reminders aren't paused or snoozed, they haven't been reminded
+// today, and they haven't logged a mood today, all in their own time zone.
+//
+// Claiming and marking happen in one statement, so API instances running
+// the scheduler at the same time never remind the same user twice in a day.
+func (m *ReminderModel) ClaimDue(window time.Duration, limit int) ([]*DueReminder, error) {
+	query := `
+		UPDATE user_settings
+		SET reminder_sent_on = (NOW() AT TIME ZONE user_settings.timezone)::date
+		FROM users
+		WHERE users.id = user_settings.user_id
+		AND user_settings.user_id IN (
+			SELECT s.user_id FROM user_settings s
+			INNER JOIN users u ON u.id = s.user_id
+			WHERE s.reminder_time IS NOT NULL
+			AND s.reminders_paused = false
+			AND (s.reminders_snoozed_until IS NULL OR s.reminders_snoozed_until <= NOW())
+			AND (NOW() AT TIME ZONE s.timezone)::time - s.reminder_time BETWEEN INTERVAL '0' AND make_interval(secs => $1)
+			AND s.reminder_sent_on IS DISTINCT FROM (NOW() AT TIME ZONE s.timezone)::date
+			AND u.activated = true AND u.locked = false AND u.deletion_scheduled_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM moods
+				WHERE moods.user_id = s.user_id
+				AND moods.created_at >= (NOW() AT TIME ZONE s.timezone)::date AT TIME ZONE s.timezone
+			)
+			ORDER BY s.user_id
+			LIMIT $2
+			FOR UPDATE OF s SKIP LOCKED
+		)
+		RETURNING users.id, users.name, users.email, user_settings.locale`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, window.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*DueReminder{}
+	for rows.Next() {
+		var reminder DueReminder
+		err := rows.Scan(&reminder.UserID, &reminder.Name, &reminder.Email, &reminder.Locale)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, &reminder)
+	}
+	return reminders, rows.Err()
+}
+
+// Unsubscribe turns off the user's daily reminders.
+func (m *ReminderModel) Unsubscribe(userID int64) error {
+	query := `
+		UPDATE user_settings
+		SET reminder_time = NULL, version = version + 1
+		WHERE user_id = $1 AND reminder_time IS NOT NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
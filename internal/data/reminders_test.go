@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReminderModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("ClaimDue", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		settingsModel := UserSettingsModel{DB: db}
+		reminderModel := ReminderModel{DB: db}
+		moodModel := MoodModel{DB: db}
+
+		newUser := func(email string) *User {
+			user := &User{Name: "Test User", Email: email, Activated: true}
+			_ = user.Password.Set("password123")
+			assert.NoError(t, userModel.Insert(user))
+
+			settings := DefaultUserSettings(user.ID)
+			settings.ReminderTime = time.Now().UTC().Format("15:04")
+			assert.NoError(t, settingsModel.Save(settings))
+			return user
+		}
+
+		due := newUser("due@example.com")
+		logged := newUser("logged@example.com")
+		assert.NoError(t, moodModel.Insert(&Mood{Title: "Today", Content: "...", Emotion: "Calm", Emoji: "🙂", Color: "#AAAAAA", UserID: logged.ID}))
+		snoozed := newUser("snoozed@example.com")
+		settings, _ := settingsModel.Get(snoozed.ID)
+		tomorrow := time.Now().Add(24 * time.Hour)
+		settings.RemindersSnoozedUntil = &tomorrow
+		assert.NoError(t, settingsModel.Save(settings))
+
+		reminders, err := reminderModel.ClaimDue(time.Hour, 100)
+		assert.NoError(t, err)
+		if assert.Len(t, reminders, 1) {
+			assert.Equal(t, due.ID, reminders[0].UserID)
+		}
+
+		// Each user is only reminded once a day.
+		reminders, err = reminderModel.ClaimDue(time.Hour, 100)
+		assert.NoError(t, err)
+		assert.Empty(t, reminders)
+
+		assert.NoError(t, reminderModel.Unsubscribe(due.ID))
+		settings, err = settingsModel.Get(due.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, settings.ReminderTime)
+	})
+}
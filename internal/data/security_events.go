@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Security event types.
+const (
+	EventRegistered        = "registered"
+	EventActivated         = "activated"
+	EventLoginSucceeded    = "login_succeeded"
+	EventLoginFailed       = "login_failed"
+	EventPasswordChanged   = "password_changed"
+	EventPasswordReset     = "password_reset"
+	EventEmailChanged      = "email_changed"
+	EventTokenRevoked      = "token_revoked"
+	EventDeletionScheduled = "deletion_scheduled"
+	EventDeletionCancelled = "deletion_cancelled"
+	EventAccountDeleted    = "account_deleted"
+	EventDataExported      = "data_exported"
+	EventGuestCreated      = "guest_created"
+)
+
+// AdminActionEvent is the security event type for one of the AdminAction*
+// actions.
+func AdminActionEvent(action string) string {
+	return "admin_" + action
+}
+
+// SecurityEvent is one entry in an account's audit trail. UserID is not a
+// foreign key, so the trail outlives the account. ActorID is set when
+// someone other than the user, such as an admin, caused the event.
+type SecurityEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"user_id"`
+	ActorID   *int64    `json:"actor_id,omitempty"`
+	Event     string    `json:"event"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id"`
+}
+
+// SecurityEventModel stores the audit trail of account events.
+type SecurityEventModel struct {
+	DB *sql.DB
+}
+
+func (m *SecurityEventModel) Insert(event *SecurityEvent) error {
+	query := `
+		INSERT INTO security_events (user_id, actor_id, event, ip_address, user_agent, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	args := []interface{}{event.UserID, event.ActorID, event.Event, event.IPAddress, event.UserAgent, event.RequestID}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// GetAll returns a page of events. userID and event are only filtered on
+// when they are not zero.
+func (m *SecurityEventModel) GetAll(userID int64, event string, filters Filters) ([]*SecurityEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, user_id, actor_id, event, ip_address, user_agent, request_id
+		FROM security_events
+		WHERE (user_id = $1 OR $1 = 0)
+		AND (event = $2 OR $2 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, event, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	events := []*SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		err := rows.Scan(&totalRecords, &e.ID, &e.CreatedAt, &e.UserID, &e.ActorID, &e.Event, &e.IPAddress, &e.UserAgent, &e.RequestID)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return events, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetAllForUser returns every event recorded for the user, newest first.
+func (m *SecurityEventModel) GetAllForUser(userID int64) ([]*SecurityEvent, error) {
+	query := `
+		SELECT id, created_at, user_id, actor_id, event, ip_address, user_agent, request_id
+		FROM security_events
+		WHERE user_id = $1
+		ORDER BY id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.UserID, &e.ActorID, &e.Event, &e.IPAddress, &e.UserAgent, &e.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
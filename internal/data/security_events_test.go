@@ -0,0 +1,57 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminActionEvent(t *testing.T) {
+	assert.Equal(t, "admin_lock", AdminActionEvent(AdminActionLock))
+}
+
+func TestSecurityEventModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Insert and GetAll", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		eventModel := SecurityEventModel{DB: db}
+
+		adminID := int64(99)
+		for _, e := range []*SecurityEvent{
+			{UserID: 1, Event: EventRegistered, IPAddress: "127.0.0.1", UserAgent: "curl/8.0", RequestID: "req-1"},
+			{UserID: 1, Event: EventLoginFailed, IPAddress: "127.0.0.1", UserAgent: "curl/8.0", RequestID: "req-2"},
+			{UserID: 1, Event: EventLoginSucceeded, IPAddress: "127.0.0.1", UserAgent: "curl/8.0", RequestID: "req-3"},
+			{UserID: 2, ActorID: &adminID, Event: AdminActionEvent(AdminActionLock), IPAddress: "10.0.0.1", UserAgent: "", RequestID: "req-4"},
+		} {
+			assert.NoError(t, eventModel.Insert(e))
+			assert.NotZero(t, e.ID)
+		}
+
+		filters := Filters{Page: 1, PageSize: 20, Sort: "-id", SortSafeList: []string{"id", "-id"}}
+
+		events, metadata, err := eventModel.GetAll(1, "", filters)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), metadata.TotalRecords)
+		if assert.Len(t, events, 3) {
+			assert.Equal(t, EventLoginSucceeded, events[0].Event)
+			assert.Equal(t, "req-3", events[0].RequestID)
+			assert.Nil(t, events[0].ActorID)
+		}
+
+		events, _, err = eventModel.GetAll(1, EventLoginFailed, filters)
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+
+		// Zero means every user.
+		events, _, err = eventModel.GetAll(0, "", filters)
+		assert.NoError(t, err)
+		if assert.Len(t, events, 4) {
+			assert.Equal(t, &adminID, events[0].ActorID)
+		}
+	})
+}
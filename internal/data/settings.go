@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"feel-flow-api/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Measurement unit systems.
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
+var (
+	// LocaleRX matches simple BCP 47 language tags such as "en" or "pt-BR".
+	LocaleRX = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+	// TimezoneRX matches the shape of IANA time zone names such as "UTC" or
+	// "America/Argentina/Buenos_Aires", and rules out file paths.
+	TimezoneRX = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*$`)
+	// ReminderTimeRX matches a 24-hour "HH:MM" time of day.
+	ReminderTimeRX = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// weekdays maps the accepted week_start values to their time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sunday":   time.Sunday,
+	"monday":   time.Monday,
+	"saturday": time.Saturday,
+}
+
+// UserSettings are a user's preferences. Anything that works in days, such
+// as streaks, reminders or digests, should use Location rather than UTC.
+type UserSettings struct {
+	UserID      int64    `json:"-"`
+	Timezone    string   `json:"timezone"`
+	Locale      string   `json:"locale"`
+	WeekStart   string   `json:"week_start"`
+	Units       string   `json:"units"`
+	MoodPalette []string `json:"mood_palette"`
+	// ReminderTime is the local "HH:MM" time to send a daily reminder, or
+	// "" for none.
+	ReminderTime string `json:"reminder_time"`
+	// RemindersPaused stops reminders until it is cleared, while
+	// RemindersSnoozedUntil stops them until a given time.
+	RemindersPaused       bool       `json:"reminders_paused"`
+	RemindersSnoozedUntil *time.Time `json:"reminders_snoozed_until"`
+	// WeeklyDigest opts in to a summary of the past week, emailed on
+	// DigestDay, such as "sunday".
+	WeeklyDigest bool   `json:"weekly_digest"`
+	DigestDay    string `json:"digest_day"`
+	Version      int    `json:"-"`
+}
+
+// DefaultUserSettings returns the settings of a user who hasn't changed any.
+func DefaultUserSettings(userID int64) *UserSettings {
+	return &UserSettings{
+		UserID:      userID,
+		Timezone:    "UTC",
+		Locale:      "en",
+		WeekStart:   "monday",
+		Units:       UnitsMetric,
+		MoodPalette: []string{"#FFD93D", "#6BCB77", "#4D96FF", "#FF6B6B", "#9B59B6", "#95A5A6"},
+		DigestDay:   "sunday",
+	}
+}
+
+// Location returns the user's time zone. Settings are validated before they
+// are saved, so this only falls back to UTC if the zone database changes.
+func (s *UserSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// FirstDayOfWeek returns the day the user's week starts on.
+func (s *UserSettings) FirstDayOfWeek() time.Weekday {
+	return weekdays[s.WeekStart]
+}
+
+// validDigestDay reports whether day is the lowercase name of a weekday.
+func validDigestDay(day string) bool {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if day == strings.ToLower(d.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateUserSettings(v *validator.Validator, s *UserSettings) {
+	// time.LoadLocation also accepts "Local", the server's own zone, which
+	// PostgreSQL doesn't know. UserSettingsModel.TimezoneKnown checks the
+	// name against PostgreSQL's own zone database.
+	_, err := time.LoadLocation(s.Timezone)
+	v.Check(validator.Matches(s.Timezone, TimezoneRX) && s.Timezone != "Local" && err == nil, "timezone", "must be a valid IANA time zone, such as Europe/London")
+
+	v.Check(validator.Matches(s.Locale, LocaleRX), "locale", "must be a language tag such as en or pt-BR")
+
+	_, ok := weekdays[s.WeekStart]
+	v.Check(ok, "week_start", "must be sunday, monday or saturday")
+
+	v.Check(validator.PermittedValue(s.Units, UnitsMetric, UnitsImperial), "units", "must be metric or imperial")
+
+	v.Check(len(s.MoodPalette) >= 1, "mood_palette", "must contain at least 1 color")
+	v.Check(len(s.MoodPalette) <= 12, "mood_palette", "must not contain more than 12 colors")
+	v.Check(validator.Unique(s.MoodPalette), "mood_palette", "must not contain duplicate colors")
+	v.Check(!slices.Contains(s.MoodPalette, ""), "mood_palette", "must not contain empty colors")
+	v.Check(!slices.ContainsFunc(s.MoodPalette, func(c string) bool { return len(c) > 20 }), "mood_palette", "colors must not be more than 20 bytes long")
+
+	v.Check(s.ReminderTime == "" || validator.Matches(s.ReminderTime, ReminderTimeRX), "reminder_time", "must be a 24-hour HH:MM time, or empty for no reminder")
+	v.Check(validDigestDay(s.DigestDay), "digest_day", "must be a day of the week, such as sunday")
+	v.Check(s.RemindersSnoozedUntil == nil || s.RemindersSnoozedUntil.Before(time.Now().AddDate(1, 0, 0)), "reminders_snoozed_until", "must not be more than a year from now")
+}
+
+// UserSettingsModel stores user preferences.
+type UserSettingsModel struct {
+	DB *sql.DB
+}
+
+// TimezoneKnown reports whether PostgreSQL knows the time zone name, so that
+// queries converting times to the user's zone can't fail.
+func (m *UserSettingsModel) TimezoneKnown(name string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var known bool
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&known)
+	return known, err
+}
+
+// Get returns the user's settings, or the defaults if they haven't saved any.
+func (m *UserSettingsModel) Get(userID int64) (*UserSettings, error) {
+	query := `
+		SELECT timezone, locale, week_start, units, mood_palette,
+			COALESCE(to_char(reminder_time, 'HH24:MI'), ''), reminders_paused, reminders_snoozed_until,
+			weekly_digest, digest_day, version
+		FROM user_settings
+		WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	settings := UserSettings{UserID: userID}
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&settings.Timezone,
+		&settings.Locale,
+		&settings.WeekStart,
+		&settings.Units,
+		pq.Array(&settings.MoodPalette),
+		&settings.ReminderTime,
+		&settings.RemindersPaused,
+		&settings.RemindersSnoozedUntil,
+		&settings.WeeklyDigest,
+		&settings.DigestDay,
+		&settings.Version,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultUserSettings(userID), nil
+		}
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Save stores the settings, failing with ErrEditConflict if they were
+// changed since they were read.
+func (m *UserSettingsModel) Save(settings *UserSettings) error {
+	query := `
+		INSERT INTO user_settings (user_id, timezone, locale, week_start, units, mood_palette, reminder_time,
+			reminders_paused, reminders_snoozed_until, weekly_digest, digest_day)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::time, $8, $9, $10, $11)
+		ON CONFLICT (user_id) DO UPDATE
+		SET timezone = EXCLUDED.timezone, locale = EXCLUDED.locale, week_start = EXCLUDED.week_start,
+			units = EXCLUDED.units, mood_palette = EXCLUDED.mood_palette, reminder_time = EXCLUDED.reminder_time,
+			reminders_paused = EXCLUDED.reminders_paused, reminders_snoozed_until = EXCLUDED.reminders_snoozed_until,
+			weekly_digest = EXCLUDED.weekly_digest, digest_day = EXCLUDED.digest_day,
+			version = user_settings.version + 1
+		WHERE user_settings.version = $12
+		RETURNING version`
+	args := []interface{}{
+		settings.UserID,
+		settings.Timezone,
+		settings.Locale,
+		settings.WeekStart,
+		settings.Units,
+		pq.Array(settings.MoodPalette),
+		settings.ReminderTime,
+		settings.RemindersPaused,
+		settings.RemindersSnoozedUntil,
+		settings.WeeklyDigest,
+		settings.DigestDay,
+		settings.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&settings.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+	return nil
+}
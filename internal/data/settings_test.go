@@ -0,0 +1,117 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"feel-flow-api/internal/validator"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUserSettings(t *testing.T) {
+	v := validator.New()
+	ValidateUserSettings(v, DefaultUserSettings(1))
+	assert.True(t, v.IsEmpty(), "default settings should be valid: %v", v.Errors)
+
+	tests := []struct {
+		name   string
+		change func(s *UserSettings)
+		field  string
+	}{
+		{"unknown time zone", func(s *UserSettings) { s.Timezone = "Mars/Olympus_Mons" }, "timezone"},
+		{"empty time zone", func(s *UserSettings) { s.Timezone = "" }, "timezone"},
+		{"server's local time zone", func(s *UserSettings) { s.Timezone = "Local" }, "timezone"},
+		{"time zone path", func(s *UserSettings) { s.Timezone = "/etc/localtime" }, "timezone"},
+		{"bad locale", func(s *UserSettings) { s.Locale = "english" }, "locale"},
+		{"bad week start", func(s *UserSettings) { s.WeekStart = "wednesday" }, "week_start"},
+		{"bad units", func(s *UserSettings) { s.Units = "furlongs" }, "units"},
+		{"empty palette", func(s *UserSettings) { s.MoodPalette = []string{} }, "mood_palette"},
+		{"duplicate colors", func(s *UserSettings) { s.MoodPalette = []string{"#FFFFFF", "#FFFFFF"} }, "mood_palette"},
+		{"bad reminder time", func(s *UserSettings) { s.ReminderTime = "25:00" }, "reminder_time"},
+		{"bad digest day", func(s *UserSettings) { s.DigestDay = "someday" }, "digest_day"},
+		{"long snooze", func(s *UserSettings) { until := time.Now().AddDate(2, 0, 0); s.RemindersSnoozedUntil = &until }, "reminders_snoozed_until"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultUserSettings(1)
+			tt.change(s)
+			v := validator.New()
+			ValidateUserSettings(v, s)
+			assert.Contains(t, v.Errors, tt.field)
+		})
+	}
+
+	s := DefaultUserSettings(1)
+	s.Timezone = "America/Belize"
+	s.Locale = "es-BZ"
+	s.WeekStart = "sunday"
+	s.ReminderTime = "20:30"
+	s.WeeklyDigest = true
+	s.DigestDay = "friday"
+	v = validator.New()
+	ValidateUserSettings(v, s)
+	assert.True(t, v.IsEmpty(), "%v", v.Errors)
+}
+
+func TestUserSettings_Location(t *testing.T) {
+	s := DefaultUserSettings(1)
+	assert.Equal(t, time.UTC, s.Location())
+	assert.Equal(t, time.Monday, s.FirstDayOfWeek())
+
+	s.Timezone = "America/Belize"
+	s.WeekStart = "sunday"
+	assert.Equal(t, "America/Belize", s.Location().String())
+	assert.Equal(t, time.Sunday, s.FirstDayOfWeek())
+}
+
+func TestUserSettingsModel_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	t.Run("Get and Save", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		settingsModel := UserSettingsModel{DB: db}
+
+		user := &User{Name: "Test User", Email: "test@example.com", Activated: true}
+		_ = user.Password.Set("password123")
+		_ = userModel.Insert(user)
+
+		// Users who haven't saved settings get the defaults.
+		settings, err := settingsModel.Get(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultUserSettings(user.ID), settings)
+
+		settings.Timezone = "America/Belize"
+		settings.ReminderTime = "08:15"
+		assert.NoError(t, settingsModel.Save(settings))
+		assert.Equal(t, 1, settings.Version)
+
+		saved, err := settingsModel.Get(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "America/Belize", saved.Timezone)
+		assert.Equal(t, "08:15", saved.ReminderTime)
+
+		saved.ReminderTime = ""
+		assert.NoError(t, settingsModel.Save(saved))
+		assert.Equal(t, 2, saved.Version)
+
+		// Saving a stale copy is an edit conflict.
+		assert.ErrorIs(t, settingsModel.Save(settings), ErrEditConflict)
+
+		saved, err = settingsModel.Get(user.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, saved.ReminderTime)
+
+		known, err := settingsModel.TimezoneKnown("America/Belize")
+		assert.NoError(t, err)
+		assert.True(t, known)
+		known, err = settingsModel.TimezoneKnown("Local")
+		assert.NoError(t, err)
+		assert.False(t, known)
+	})
+}
@@ -14,6 +14,12 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // We'll use this later
+	ScopeMFAPending     = "mfa-pending"    // Password checked, waiting for the second factor.
+	ScopePasswordReset  = "password-reset"
+	ScopeMagicLink      = "magic-link"
+	ScopeDataExport     = "data-export" // Downloads the user's data export.
+	ScopeGuest          = "guest"       // A guest account's device token.
+	ScopeUnsubscribe    = "unsubscribe" // Turns off reminder emails from a link.
 )
 
 // Token holds the data for an individual token.
@@ -87,4 +93,61 @@ func (m *TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
+}
+
+// TokenInfo describes an active token without revealing it.
+type TokenInfo struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// GetAllActiveForUser describes the user's unexpired tokens, soonest to expire first.
+func (m *TokenModel) GetAllActiveForUser(userID int64) ([]*TokenInfo, error) {
+	query := `
+		SELECT scope, expiry
+		FROM tokens
+		WHERE user_id = $1 AND expiry > NOW()
+		ORDER BY expiry`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*TokenInfo{}
+	for rows.Next() {
+		var token TokenInfo
+		if err := rows.Scan(&token.Scope, &token.Expiry); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, rows.Err()
+}
+
+// Delete removes a single token, identified by its plaintext, for the given scope.
+func (m *TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	return err
+}
+
+// DeleteExpired removes expired tokens of every scope.
+func (m *TokenModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM tokens WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
\ No newline at end of file
@@ -5,10 +5,14 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"feel-flow-api/internal/passhash"
 	"feel-flow-api/internal/validator"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 // AnonymousUser represents a user that is not logged in.
@@ -22,6 +26,8 @@ type User struct {
 	Email       string    `json:"email"`
 	Password    password  `json:"-"` // This will not be exposed in JSON responses.
 	Activated   bool      `json:"activated"`
+	Locked      bool      `json:"-"`     // Set by an admin; a locked account cannot sign in.
+	Guest       bool      `json:"guest"` // An anonymous account that hasn't registered yet.
 	Version     int       `json:"-"`
 }
 
@@ -30,35 +36,79 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// passwordHasher hashes new passwords and verifies stored ones. Argon2id is
+// the default; bcrypt hashes from older accounts still verify and are
+// upgraded the next time their owner logs in.
+var passwordHasher = passhash.NewHasher(passhash.DefaultArgon2id, passhash.Bcrypt{Cost: 12})
+
+// SetPasswordHasher replaces the password hasher. Call it once at startup,
+// before any passwords are hashed or checked.
+func SetPasswordHasher(h *passhash.Hasher) {
+	passwordHasher = h
+	dummyPasswordHash = sync.OnceValue(newDummyPasswordHash)
+}
+
 // password is a custom type to handle plaintext and hashed passwords.
 type password struct {
 	plaintext *string
 	hash      []byte
+	rehashed  bool
 }
 
-// Set generates a bcrypt hash of a plaintext password.
+// Set hashes a plaintext password with the configured hasher.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := passwordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
 	p.plaintext = &plaintextPassword
-	p.hash = hash
+	p.hash = []byte(hash)
 	return nil
 }
 
-// Matches compares a plaintext password with the stored hash.
+// Matches compares a plaintext password with the stored hash. If the password
+// matches but the hash is from an outdated scheme or uses weaker parameters
+// than are now configured, the hash is replaced and Rehashed reports true so
+// the caller can save it with UserModel.Update.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return false, nil
-		}
+	match, needsRehash, err := passwordHasher.Verify(string(p.hash), plaintextPassword)
+	if err != nil || !match {
 		return false, err
 	}
+	if needsRehash {
+		err = p.Set(plaintextPassword)
+		if err != nil {
+			return false, err
+		}
+		p.rehashed = true
+	}
 	return true, nil
 }
 
+// Rehashed reports whether Matches upgraded the hash, which then needs saving.
+func (p *password) Rehashed() bool {
+	return p.rehashed
+}
+
+// dummyPasswordHash is compared against when a login names an unknown email,
+// so those requests take as long as ones for real accounts.
+var dummyPasswordHash = sync.OnceValue(newDummyPasswordHash)
+
+func newDummyPasswordHash() string {
+	hash, err := passwordHasher.Hash("feel-flow-dummy-password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// DummyPasswordCheck does the same work as Matches without a real account.
+// Call it when a user can't be found to stop attackers from discovering
+// which email addresses are registered by timing the response.
+func DummyPasswordCheck(plaintextPassword string) {
+	_, _, _ = passwordHasher.Verify(dummyPasswordHash(), plaintextPassword)
+}
+
 // --- Validation Functions ---
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -69,7 +119,20 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= passwordHasher.MaxLength(), "password", fmt.Sprintf("must not be more than %d bytes long", passwordHasher.MaxLength()))
+}
+
+// ValidatePasswordStrength rejects new passwords that appear in known
+// breaches or are too easy to guess. userInputs are the user's own details,
+// such as their name and email, which shouldn't be part of their password.
+// It is only for choosing a password, never for checking one at login.
+func ValidatePasswordStrength(v *validator.Validator, password string, userInputs ...string) {
+	if validator.IsBreachedPassword(password) {
+		v.AddError("password", "is too common and has appeared in data breaches; choose a different password")
+		return
+	}
+	strength := validator.PasswordStrength(password, userInputs...)
+	v.Check(strength.Score >= validator.MinPasswordScore, "password", "is too easy to guess: "+strings.Join(strength.Feedback, "; "))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -80,6 +143,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordStrength(v, *user.Password.plaintext, user.Name, user.Email)
 	}
 	
 	if user.Password.hash == nil {
@@ -95,11 +159,11 @@ type UserModel struct {
 
 func (m *UserModel) Insert(user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, guest, activated_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $4 THEN NOW() END)
 		RETURNING id, created_at, version`
 
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.Guest}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -115,7 +179,7 @@ func (m *UserModel) Insert(user *User) error {
 
 func (m *UserModel) GetByEmail(email string) (*User, error) {
     query := `
-        SELECT id, created_at, name, email, password_hash, activated, version
+        SELECT id, created_at, name, email, password_hash, activated, locked, guest, version
         FROM users
         WHERE email = $1`
 
@@ -130,6 +194,43 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
         &user.Email,
         &user.Password.hash,
         &user.Activated,
+        &user.Locked,
+        &user.Guest,
+        &user.Version,
+    )
+
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, ErrRecordNotFound
+        }
+        return nil, err
+    }
+    return &user, nil
+}
+
+func (m *UserModel) Get(id int64) (*User, error) {
+    if id < 1 {
+        return nil, ErrRecordNotFound
+    }
+
+    query := `
+        SELECT id, created_at, name, email, password_hash, activated, locked, guest, version
+        FROM users
+        WHERE id = $1`
+
+    var user User
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+    err := m.DB.QueryRowContext(ctx, query, id).Scan(
+        &user.ID,
+        &user.CreatedAt,
+        &user.Name,
+        &user.Email,
+        &user.Password.hash,
+        &user.Activated,
+        &user.Locked,
+        &user.Guest,
         &user.Version,
     )
 
@@ -145,8 +246,10 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 func (m *UserModel) Update(user *User) error {
     query := `
         UPDATE users
-        SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-        WHERE id = $5 AND version = $6
+        SET name = $1, email = $2, password_hash = $3, activated = $4, guest = $5, version = version + 1,
+            activated_at = CASE WHEN $4 THEN COALESCE(activated_at, NOW()) ELSE activated_at END,
+            activation_reminder_sent_at = CASE WHEN $4 THEN NULL ELSE activation_reminder_sent_at END
+        WHERE id = $6 AND version = $7
         RETURNING version`
 
     args := []interface{}{
@@ -154,6 +257,7 @@ func (m *UserModel) Update(user *User) error {
         user.Email,
         user.Password.hash,
         user.Activated,
+        user.Guest,
         user.ID,
         user.Version,
     }
@@ -186,7 +290,8 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
         INNER JOIN tokens ON users.id = tokens.user_id
         WHERE tokens.hash = $1
         AND tokens.scope = $2
-        AND tokens.expiry > $3`
+        AND tokens.expiry > $3
+        AND users.locked = false`
 
     args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
@@ -231,4 +336,301 @@ func (m *UserModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 	return nil
+}
+
+// credentialTables are the tables holding ways into an account other than
+// its password.
+var credentialTables = []string{
+	"tokens",
+	"api_keys",
+	"webauthn_credentials",
+	"totp_credentials",
+	"recovery_codes",
+	"oauth_codes",
+	"oauth_tokens",
+	"oauth_grants",
+	"user_identities",
+}
+
+// DeleteCredentials deletes every token, API key, passkey, second factor,
+// OAuth grant and linked identity of the account, so that whoever set them
+// up can no longer get in.
+func (m *UserModel) DeleteCredentials(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range credentialTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ScheduleDeletion marks the account to be deleted at the given time.
+func (m *UserModel) ScheduleDeletion(id int64, at time.Time) error {
+	query := `UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, at, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CancelDeletion unschedules the account's deletion, and reports whether
+// one was scheduled.
+func (m *UserModel) CancelDeletion(id int64) (bool, error) {
+	query := `
+		UPDATE users SET deletion_scheduled_at = NULL
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteScheduled deletes accounts whose scheduled deletion time has passed,
+// along with everything that references them, and returns their IDs.
+func (m *UserModel) DeleteScheduled() ([]int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE deletion_scheduled_at <= NOW()
+		RETURNING id`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ClaimForActivationReminder marks up to limit accounts that have been
+// waiting for activation for longer than age, and haven't been reminded yet,
+// as reminded and returns them. Accounts that were activated once and later
+// deactivated by an admin are left alone. Claiming and marking happen in one
+// statement, so API instances running the job at the same time never remind
+// the same user twice.
+func (m *UserModel) ClaimForActivationReminder(age time.Duration, limit int) ([]*User, error) {
+	query := `
+		UPDATE users
+		SET activation_reminder_sent_at = NOW()
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE activated_at IS NULL AND locked = false AND guest = false AND activation_reminder_sent_at IS NULL AND created_at < $1
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, name, email, password_hash, activated, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(-age), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUnactivated deletes accounts that have never been activated more
+// than grace after they were sent an activation reminder, and returns how
+// many were deleted.
+func (m *UserModel) DeleteUnactivated(grace time.Duration) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE activated_at IS NULL AND activation_reminder_sent_at < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-grace))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AccountSummary is the view of an account shown to admins. It never
+// includes mood content.
+type AccountSummary struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Activated bool      `json:"activated"`
+	Locked    bool      `json:"locked"`
+}
+
+// AccountDetails adds counts of what the account holds to its summary.
+type AccountDetails struct {
+	AccountSummary
+	MoodCount    int64       `json:"mood_count"`
+	LastMoodAt   *time.Time  `json:"last_mood_at"`
+	PasskeyCount int64       `json:"passkey_count"`
+	APIKeyCount  int64       `json:"api_key_count"`
+	MFAEnabled   bool        `json:"mfa_enabled"`
+	Permissions  Permissions `json:"permissions"`
+	// DeletionScheduledAt is set while the user's request to delete the
+	// account is waiting out its grace period.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+}
+
+// Search returns a page of accounts whose name or email contains query.
+// activated and locked are only filtered on when not nil.
+func (m *UserModel) Search(query string, activated, locked *bool, filters Filters) ([]*AccountSummary, Metadata, error) {
+	stmt := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, email, activated, locked
+		FROM users
+		WHERE (name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%')
+		AND ($2::bool IS NULL OR activated = $2)
+		AND ($3::bool IS NULL OR locked = $3)
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, escapeLike(query), activated, locked, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := int64(0)
+	accounts := []*AccountSummary{}
+	for rows.Next() {
+		var account AccountSummary
+		err := rows.Scan(&totalRecords, &account.ID, &account.CreatedAt, &account.Name, &account.Email, &account.Activated, &account.Locked)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		accounts = append(accounts, &account)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return accounts, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GetAccountDetails returns the summary of one account along with how many
+// moods, passkeys and API keys it has.
+func (m *UserModel) GetAccountDetails(id int64) (*AccountDetails, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.activated, users.locked,
+			(SELECT COUNT(*) FROM moods WHERE moods.user_id = users.id),
+			(SELECT MAX(created_at) FROM moods WHERE moods.user_id = users.id),
+			(SELECT COUNT(*) FROM webauthn_credentials WHERE webauthn_credentials.user_id = users.id),
+			(SELECT COUNT(*) FROM api_keys WHERE api_keys.user_id = users.id),
+			EXISTS (SELECT 1 FROM totp_credentials WHERE totp_credentials.user_id = users.id AND confirmed),
+			ARRAY(
+				SELECT permissions.code FROM permissions
+				INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+				WHERE users_permissions.user_id = users.id
+				ORDER BY permissions.code
+			),
+			users.deletion_scheduled_at
+		FROM users
+		WHERE users.id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var details AccountDetails
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&details.ID,
+		&details.CreatedAt,
+		&details.Name,
+		&details.Email,
+		&details.Activated,
+		&details.Locked,
+		&details.MoodCount,
+		&details.LastMoodAt,
+		&details.PasskeyCount,
+		&details.APIKeyCount,
+		&details.MFAEnabled,
+		pq.Array((*[]string)(&details.Permissions)),
+		&details.DeletionScheduledAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &details, nil
+}
+
+// SetLocked locks or unlocks an account. It doesn't touch the version, so it
+// can't cause an edit conflict for the user.
+func (m *UserModel) SetLocked(id int64, locked bool) error {
+	query := `UPDATE users SET locked = $1 WHERE id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, locked, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
 }
\ No newline at end of file
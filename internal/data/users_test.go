@@ -55,14 +55,14 @@ func newTestDB(t *testing.T) (*sql.DB, func()) {
 	// Truncate all relevant tables to ensure a clean state.
 	// RESTART IDENTITY resets auto-incrementing counters.
 	// CASCADE will also truncate any tables that have foreign keys to these tables.
-	_, err := testDB.Exec(`TRUNCATE TABLE moods, users, tokens RESTART IDENTITY CASCADE`)
+	_, err := testDB.Exec(`TRUNCATE TABLE moods, users, tokens, admin_actions, security_events, email_messages RESTART IDENTITY CASCADE`)
 	if err != nil {
 		t.Fatalf("failed to truncate tables: %s", err)
 	}
 
 	// The teardown function to be called after the test finishes.
 	teardown := func() {
-		_, err := testDB.Exec(`TRUNCATE TABLE moods, users, tokens RESTART IDENTITY CASCADE`)
+		_, err := testDB.Exec(`TRUNCATE TABLE moods, users, tokens, admin_actions, security_events, email_messages RESTART IDENTITY CASCADE`)
 		if err != nil {
 			t.Fatalf("failed to truncate tables during teardown: %s", err)
 		}
@@ -113,4 +113,145 @@ func TestUserModel_Integration(t *testing.T) {
 		assert.NoError(t, err)
 		assert.True(t, match)
 	})
+
+	t.Run("Activation reminders and purge", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		tokenModel := TokenModel{DB: db}
+
+		waiting := &User{Name: "Waiting", Email: "waiting@example.com"}
+		_ = waiting.Password.Set("pa55word")
+		assert.NoError(t, userModel.Insert(waiting))
+		active := &User{Name: "Active", Email: "active@example.com", Activated: true}
+		_ = active.Password.Set("pa55word")
+		assert.NoError(t, userModel.Insert(active))
+
+		// Only accounts older than the reminder age are reminded, and only once.
+		reminded, err := userModel.ClaimForActivationReminder(time.Hour, 100)
+		assert.NoError(t, err)
+		assert.Empty(t, reminded)
+
+		reminded, err = userModel.ClaimForActivationReminder(-time.Minute, 100)
+		assert.NoError(t, err)
+		assert.Len(t, reminded, 1)
+		assert.Equal(t, waiting.ID, reminded[0].ID)
+
+		reminded, err = userModel.ClaimForActivationReminder(-time.Minute, 100)
+		assert.NoError(t, err)
+		assert.Empty(t, reminded)
+
+		// Accounts are only purged once the grace period after the reminder is over.
+		deleted, err := userModel.DeleteUnactivated(time.Hour)
+		assert.NoError(t, err)
+		assert.Zero(t, deleted)
+
+		deleted, err = userModel.DeleteUnactivated(-time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		_, err = userModel.Get(active.ID)
+		assert.NoError(t, err)
+
+		// An account that was reminded, activated and later deactivated by
+		// an admin is neither reminded again nor purged.
+		deactivated := &User{Name: "Deactivated", Email: "deactivated@example.com"}
+		_ = deactivated.Password.Set("pa55word")
+		assert.NoError(t, userModel.Insert(deactivated))
+		reminded, err = userModel.ClaimForActivationReminder(-time.Minute, 100)
+		assert.NoError(t, err)
+		assert.Len(t, reminded, 1)
+
+		deactivated.Activated = true
+		assert.NoError(t, userModel.Update(deactivated))
+		deactivated.Activated = false
+		assert.NoError(t, userModel.Update(deactivated))
+
+		reminded, err = userModel.ClaimForActivationReminder(-time.Minute, 100)
+		assert.NoError(t, err)
+		assert.Empty(t, reminded)
+		deleted, err = userModel.DeleteUnactivated(-time.Minute)
+		assert.NoError(t, err)
+		assert.Zero(t, deleted)
+		_, err = userModel.Get(deactivated.ID)
+		assert.NoError(t, err)
+
+		// Expired tokens of any scope are cleaned up.
+		_, err = tokenModel.New(active.ID, -time.Minute, ScopeActivation)
+		assert.NoError(t, err)
+		_, err = tokenModel.New(active.ID, time.Hour, ScopeAuthentication)
+		assert.NoError(t, err)
+		deleted, err = tokenModel.DeleteExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+	})
+
+	t.Run("Scheduled deletion", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		moodModel := MoodModel{DB: db}
+
+		user := &User{Name: "Leaving", Email: "leaving@example.com", Activated: true}
+		_ = user.Password.Set("pa55word")
+		assert.NoError(t, userModel.Insert(user))
+		mood := &Mood{Title: "Last day", Content: "Goodbye", Emotion: "sad", Emoji: "😢", Color: "#0000FF", UserID: user.ID}
+		assert.NoError(t, moodModel.Insert(mood))
+
+		// Logging in during the grace period cancels the deletion.
+		assert.NoError(t, userModel.ScheduleDeletion(user.ID, time.Now().Add(-time.Minute)))
+		cancelled, err := userModel.CancelDeletion(user.ID)
+		assert.NoError(t, err)
+		assert.True(t, cancelled)
+		cancelled, err = userModel.CancelDeletion(user.ID)
+		assert.NoError(t, err)
+		assert.False(t, cancelled)
+
+		ids, err := userModel.DeleteScheduled()
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+
+		// Accounts are only deleted once the grace period is over.
+		assert.NoError(t, userModel.ScheduleDeletion(user.ID, time.Now().Add(time.Hour)))
+		ids, err = userModel.DeleteScheduled()
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+
+		assert.NoError(t, userModel.ScheduleDeletion(user.ID, time.Now().Add(-time.Minute)))
+		ids, err = userModel.DeleteScheduled()
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{user.ID}, ids)
+
+		// The user's moods go with them.
+		_, err = moodModel.Get(mood.ID)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+
+	t.Run("DeleteCredentials", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		userModel := UserModel{DB: db}
+		tokenModel := TokenModel{DB: db}
+		apiKeyModel := APIKeyModel{DB: db}
+
+		user := &User{Name: "Squatter", Email: "victim@example.com"}
+		_ = user.Password.Set("pa55word")
+		assert.NoError(t, userModel.Insert(user))
+
+		token, err := tokenModel.New(user.ID, time.Hour, ScopeAuthentication)
+		assert.NoError(t, err)
+		_, err = apiKeyModel.New(user.ID, "laptop", []string{"moods:read"}, time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+
+		assert.NoError(t, userModel.DeleteCredentials(user.ID))
+
+		_, err = userModel.GetForToken(ScopeAuthentication, token.Plaintext)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+		keys, err := apiKeyModel.GetAllForUser(user.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	})
 }
\ No newline at end of file
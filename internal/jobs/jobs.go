@@ -0,0 +1,371 @@
+// Package jobs is a durable background job queue stored in PostgreSQL.
+// Jobs survive restarts, are retried with exponential backoff when their
+// handler fails, and are moved to a dead-letter state once they run out of
+// attempts. Workers claim jobs with FOR UPDATE SKIP LOCKED, so any number of
+// API instances can share one queue without running a job twice at once.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job statuses. Jobs that succeed are deleted.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDead    = "dead"
+)
+
+// ErrUnknownKind is returned when a job is enqueued for a kind with no
+// registered handler.
+var ErrUnknownKind = errors.New("jobs: no handler registered for kind")
+
+// Job is one unit of work in the queue.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Config controls how a Queue runs jobs.
+type Config struct {
+	// Workers is how many jobs this process runs at once.
+	Workers int
+	// PollInterval is how long an idle worker waits before looking for
+	// new jobs.
+	PollInterval time.Duration
+	// MaxAttempts is how many times a job is tried before it is dead, unless
+	// it was enqueued with its own limit.
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry. It doubles after each
+	// further failure, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// StaleAfter is how long a job may stay running before it is assumed to
+	// have died with its worker and is claimed again.
+	StaleAfter time.Duration
+}
+
+// DefaultConfig is a reasonable configuration for sending email.
+var DefaultConfig = Config{
+	Workers:      4,
+	PollInterval: time.Second,
+	MaxAttempts:  8,
+	BaseBackoff:  10 * time.Second,
+	MaxBackoff:   time.Hour,
+	StaleAfter:   15 * time.Minute,
+}
+
+// Handler runs one job. Returning an error schedules a retry.
+type Handler func(ctx context.Context, job *Job) error
+
+// Queue enqueues jobs and runs them with registered handlers.
+type Queue struct {
+	db       *sql.DB
+	config   Config
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	logger   *slog.Logger
+}
+
+// New returns a queue stored in db's jobs table. Failed jobs are logged to
+// logger.
+func New(db *sql.DB, config Config, logger *slog.Logger) *Queue {
+	return &Queue{
+		db:       db,
+		config:   config,
+		handlers: make(map[string]Handler),
+		logger:   logger,
+	}
+}
+
+// Register sets the handler for jobs of kind. Register handlers before
+// calling Run.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Handle registers fn for jobs of kind, decoding each job's payload into a T
+// first. A payload that can't be decoded will never succeed, so the job goes
+// straight to the dead-letter state.
+func Handle[T any](q *Queue, kind string, fn func(ctx context.Context, payload T) error) {
+	q.Register(kind, func(ctx context.Context, job *Job) error {
+		var payload T
+		err := json.Unmarshal(job.Payload, &payload)
+		if err != nil {
+			return permanent{fmt.Errorf("jobs: decoding %s payload: %w", kind, err)}
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// permanent marks an error that retrying can't fix.
+type permanent struct{ error }
+
+func (p permanent) Unwrap() error { return p.error }
+
+// Permanent wraps err so the job is not retried.
+func Permanent(err error) error {
+	return permanent{err}
+}
+
+// Option changes how a job is enqueued.
+type Option func(*Job)
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) Option {
+	return func(j *Job) { j.RunAt = t }
+}
+
+// MaxAttempts overrides the queue's attempt limit for the job.
+func MaxAttempts(n int) Option {
+	return func(j *Job) { j.MaxAttempts = n }
+}
+
+// Enqueue stores a job of kind with payload encoded as JSON. It runs as soon
+// as a worker is free, unless delayed with RunAt.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any, opts ...Option) (*Job, error) {
+	q.mu.RLock()
+	_, ok := q.handlers[kind]
+	q.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Kind:        kind,
+		Payload:     encoded,
+		Status:      StatusPending,
+		MaxAttempts: q.config.MaxAttempts,
+		RunAt:       time.Now(),
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	err = q.db.QueryRowContext(ctx, query, job.Kind, string(job.Payload), job.MaxAttempts, job.RunAt).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run starts the configured number of workers and blocks until ctx is
+// cancelled and every job they had started has finished.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range max(q.config.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work runs jobs one after another, waiting PollInterval whenever the
+// queue is empty, until ctx is cancelled.
+func (q *Queue) work(ctx context.Context) {
+	for {
+		ran, err := q.RunNext(ctx)
+		if err != nil {
+			q.logger.Error("failed to run job", "error", err.Error())
+		}
+		if ran && err == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.config.PollInterval):
+		}
+	}
+}
+
+// RunNext claims the next job that is due and runs it, and reports whether
+// there was one. A failing handler is not an error; the job is rescheduled
+// or marked dead.
+func (q *Queue) RunNext(ctx context.Context) (bool, error) {
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	job, err := q.claim()
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// The job runs to completion even if ctx is cancelled while it does, so
+	// shutting down doesn't leave it half done.
+	runErr := q.run(context.WithoutCancel(ctx), job)
+	if runErr == nil {
+		return true, q.complete(job)
+	}
+
+	q.logger.Error("job failed", "job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "error", runErr.Error())
+	var p permanent
+	if errors.As(runErr, &p) || job.Attempts >= job.MaxAttempts {
+		return true, q.bury(job, runErr)
+	}
+	return true, q.retry(job, runErr, Backoff(job.Attempts, q.config.BaseBackoff, q.config.MaxBackoff))
+}
+
+// run calls the job's handler, turning a panic into an error.
+func (q *Queue) run(ctx context.Context, job *Job) (err error) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+	if !ok {
+		return permanent{fmt.Errorf("%w %q", ErrUnknownKind, job.Kind)}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// Backoff returns how long to wait before retrying a job that has failed
+// attempts times: base, doubled for each further attempt, at most limit.
+func Backoff(attempts int, base, limit time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= limit {
+			return limit
+		}
+	}
+	return min(delay, limit)
+}
+
+// claim marks the next due job, or a running job that has gone stale, as
+// running and returns it.
+func (q *Queue) claim() (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' AND run_at <= NOW())
+			OR (status = 'running' AND locked_at < $1)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, status, attempts, max_attempts, run_at, COALESCE(last_error, ''), created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var job Job
+	err := q.db.QueryRowContext(ctx, query, time.Now().Add(-q.config.StaleAfter)).Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// complete deletes a job that succeeded.
+func (q *Queue) complete(job *Job) error {
+	return q.exec(`DELETE FROM jobs WHERE id = $1`, job.ID)
+}
+
+// retry puts a failed job back in the queue to run after delay.
+func (q *Queue) retry(job *Job, runErr error, delay time.Duration) error {
+	query := `
+		UPDATE jobs
+		SET status = 'pending', run_at = $1, last_error = $2, locked_at = NULL
+		WHERE id = $3`
+	return q.exec(query, time.Now().Add(delay), runErr.Error(), job.ID)
+}
+
+// bury moves a job that can't succeed to the dead-letter state, where it
+// stays until it is retried by hand with Requeue or removed by DeleteDead.
+func (q *Queue) bury(job *Job, runErr error) error {
+	query := `
+		UPDATE jobs
+		SET status = 'dead', last_error = $1, locked_at = NULL
+		WHERE id = $2`
+	return q.exec(query, runErr.Error(), job.ID)
+}
+
+// Requeue gives a dead job a fresh set of attempts, starting now.
+func (q *Queue) Requeue(ctx context.Context, id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, run_at = NOW()
+		WHERE id = $1 AND status = 'dead'`
+	result, err := q.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteDead deletes dead jobs created before the given time and returns
+// how many were deleted.
+func (q *Queue) DeleteDead(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM jobs WHERE status = 'dead' AND created_at < $1`
+	result, err := q.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (q *Queue) exec(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := q.db.ExecContext(ctx, query, args...)
+	return err
+}
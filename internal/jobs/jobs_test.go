@@ -0,0 +1,158 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base, limit := 10*time.Second, time.Minute
+	assert.Equal(t, 10*time.Second, Backoff(1, base, limit))
+	assert.Equal(t, 20*time.Second, Backoff(2, base, limit))
+	assert.Equal(t, 40*time.Second, Backoff(3, base, limit))
+	assert.Equal(t, time.Minute, Backoff(4, base, limit))
+	assert.Equal(t, time.Minute, Backoff(100, base, limit))
+}
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+// newTestQueue returns a queue backed by a mock database.
+func newTestQueue(t *testing.T) (*Queue, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	config := DefaultConfig
+	config.MaxAttempts = 3
+	return New(db, config, slog.New(slog.DiscardHandler)), mock
+}
+
+// expectClaim makes the next claim return a job of kind with payload that
+// has already been tried attempts times, including this one.
+func expectClaim(mock sqlmock.Sqlmock, kind, payload string, attempts int) {
+	columns := []string{"id", "kind", "payload", "status", "attempts", "max_attempts", "run_at", "last_error", "created_at"}
+	mock.ExpectQuery(`UPDATE jobs\s+SET status = 'running'`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, kind, []byte(payload), StatusRunning, attempts, 3, time.Now(), "", time.Now()))
+}
+
+func TestRunNext(t *testing.T) {
+	t.Run("empty queue", func(t *testing.T) {
+		q, mock := newTestQueue(t)
+		mock.ExpectQuery(`UPDATE jobs`).WillReturnError(sql.ErrNoRows)
+
+		ran, err := q.RunNext(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ran)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("success deletes the job", func(t *testing.T) {
+		q, mock := newTestQueue(t)
+		var got greeting
+		Handle(q, "greet", func(ctx context.Context, g greeting) error {
+			got = g
+			return nil
+		})
+
+		expectClaim(mock, "greet", `{"name":"Ada"}`, 1)
+		mock.ExpectExec(`DELETE FROM jobs WHERE id = \$1`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ran, err := q.RunNext(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ran)
+		assert.Equal(t, "Ada", got.Name)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failure is retried", func(t *testing.T) {
+		q, mock := newTestQueue(t)
+		Handle(q, "greet", func(ctx context.Context, g greeting) error {
+			return errors.New("smtp unavailable")
+		})
+
+		expectClaim(mock, "greet", `{"name":"Ada"}`, 1)
+		mock.ExpectExec(`UPDATE jobs\s+SET status = 'pending'`).
+			WithArgs(sqlmock.AnyArg(), "smtp unavailable", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ran, err := q.RunNext(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ran)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("last attempt is dead-lettered", func(t *testing.T) {
+		q, mock := newTestQueue(t)
+		Handle(q, "greet", func(ctx context.Context, g greeting) error {
+			panic("boom")
+		})
+
+		expectClaim(mock, "greet", `{"name":"Ada"}`, 3)
+		mock.ExpectExec(`UPDATE jobs\s+SET status = 'dead'`).
+			WithArgs("jobs: handler panicked: boom", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ran, err := q.RunNext(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ran)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("bad payload is dead-lettered straight away", func(t *testing.T) {
+		q, mock := newTestQueue(t)
+		Handle(q, "greet", func(ctx context.Context, g greeting) error {
+			return nil
+		})
+
+		expectClaim(mock, "greet", `not json`, 1)
+		mock.ExpectExec(`UPDATE jobs\s+SET status = 'dead'`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := q.RunNext(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEnqueue(t *testing.T) {
+	q, mock := newTestQueue(t)
+
+	_, err := q.Enqueue(context.Background(), "greet", greeting{Name: "Ada"})
+	assert.ErrorIs(t, err, ErrUnknownKind)
+
+	Handle(q, "greet", func(ctx context.Context, g greeting) error { return nil })
+
+	runAt := time.Now().Add(time.Hour)
+	mock.ExpectQuery(`INSERT INTO jobs`).
+		WithArgs("greet", `{"name":"Ada"}`, 5, runAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+
+	job, err := q.Enqueue(context.Background(), "greet", greeting{Name: "Ada"}, RunAt(runAt), MaxAttempts(5))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), job.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteDead(t *testing.T) {
+	q, mock := newTestQueue(t)
+
+	before := time.Now().Add(-24 * time.Hour)
+	mock.ExpectExec(`DELETE FROM jobs WHERE status = 'dead' AND created_at < \$1`).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	deleted, err := q.DeleteDead(context.Background(), before)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,245 @@
+// Package jwt issues and verifies compact JSON Web Tokens signed with
+// HS256 or EdDSA (Ed25519). Every key carries a key ID which is written to
+// the token header, so keys can be rotated by adding a new signing key while
+// keeping the old ones around for verification until their tokens expire.
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrUnknownKey   = errors.New("jwt: unknown key id")
+	ErrExpired      = errors.New("jwt: token has expired")
+	ErrNotYetValid  = errors.New("jwt: token is not valid yet")
+)
+
+var b64 = base64.RawURLEncoding
+
+// Key is a single signing/verification key identified by ID.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// NewHMACKey creates an HS256 key. The secret must be at least 32 bytes.
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("jwt: key id must not be empty")
+	}
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("jwt: HS256 key %q must be at least 32 bytes", id)
+	}
+	return &Key{ID: id, Algorithm: HS256, secret: secret}, nil
+}
+
+// NewEd25519Key creates an EdDSA key from a 32-byte Ed25519 seed.
+func NewEd25519Key(id string, seed []byte) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("jwt: key id must not be empty")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("jwt: EdDSA key %q must be a %d byte seed", id, ed25519.SeedSize)
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return &Key{
+		ID:        id,
+		Algorithm: EdDSA,
+		private:   private,
+		public:    private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// ParseKey parses a key in the "kid:base64url-material" form used on the
+// command line. For HS256 the material is the shared secret, for EdDSA it is
+// the Ed25519 seed.
+func ParseKey(algorithm, spec string) (*Key, error) {
+	id, material, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("jwt: key %q must be in the form kid:base64", spec)
+	}
+	raw, err := b64.DecodeString(strings.TrimRight(material, "="))
+	if err != nil {
+		return nil, fmt.Errorf("jwt: key %q is not valid base64url: %w", id, err)
+	}
+
+	switch algorithm {
+	case HS256:
+		return NewHMACKey(id, raw)
+	case EdDSA:
+		return NewEd25519Key(id, raw)
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", algorithm)
+	}
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case EdDSA:
+		return ed25519.Sign(k.private, input), nil
+	default:
+		return nil, fmt.Errorf("jwt: key %q cannot sign", k.ID)
+	}
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case EdDSA:
+		return ed25519.Verify(k.public, input, signature)
+	default:
+		return false
+	}
+}
+
+// KeySet holds the active signing key plus any older keys that are still
+// accepted for verification.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet creates a KeySet which signs with the key named signingKeyID.
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(keys))}
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	signing, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: signing key %q is not in the key set", signingKeyID)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Sign encodes claims as the token payload and signs it with the active key.
+func (ks *KeySet) Sign(claims any) (string, error) {
+	h, err := json.Marshal(header{Algorithm: ks.signing.Algorithm, Type: "JWT", KeyID: ks.signing.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	signature, err := ks.signing.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+// Verify checks the token signature and decodes the payload into dst. The
+// algorithm in the header must match the algorithm of the key it names, which
+// stops a token from downgrading itself to a weaker algorithm. If dst has a
+// Valid(time.Time) error method it is called to check the time-based claims.
+func (ks *KeySet) Verify(token string, dst any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	rawHeader, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if h.Algorithm != key.Algorithm {
+		return ErrInvalidToken
+	}
+
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrInvalidToken
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, dst); err != nil {
+		return ErrInvalidToken
+	}
+
+	if v, ok := dst.(interface{ Valid(time.Time) error }); ok {
+		return v.Valid(time.Now())
+	}
+	return nil
+}
+
+// RegisteredClaims holds the standard claims from RFC 7519. Embed it in an
+// application claims struct to get expiry checking from Verify.
+type RegisteredClaims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// Valid checks the exp and nbf claims against now.
+func (c RegisteredClaims) Valid(now time.Time) error {
+	if c.ExpiresAt == 0 || now.Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore {
+		return ErrNotYetValid
+	}
+	return nil
+}
+
+// Expiry returns the exp claim as a time.Time.
+func (c RegisteredClaims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
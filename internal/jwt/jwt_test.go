@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClaims struct {
+	RegisteredClaims
+	Name string `json:"name"`
+}
+
+func newTestKeySet(t *testing.T, signingKeyID string) *KeySet {
+	t.Helper()
+	hmacKey, err := NewHMACKey("h1", bytes.Repeat([]byte("s"), 32))
+	assert.NoError(t, err)
+	edKey, err := NewEd25519Key("e1", bytes.Repeat([]byte{7}, 32))
+	assert.NoError(t, err)
+	ks, err := NewKeySet(signingKeyID, hmacKey, edKey)
+	assert.NoError(t, err)
+	return ks
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, kid := range []string{"h1", "e1"} {
+		t.Run(kid, func(t *testing.T) {
+			ks := newTestKeySet(t, kid)
+			claims := testClaims{
+				RegisteredClaims: RegisteredClaims{Subject: "42", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+				Name:             "Alice",
+			}
+
+			token, err := ks.Sign(claims)
+			assert.NoError(t, err)
+
+			var got testClaims
+			assert.NoError(t, ks.Verify(token, &got))
+			assert.Equal(t, claims, got)
+		})
+	}
+}
+
+func TestVerifyRejectsTamperedAndExpiredTokens(t *testing.T) {
+	ks := newTestKeySet(t, "e1")
+
+	expired, err := ks.Sign(testClaims{RegisteredClaims: RegisteredClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()}})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, ks.Verify(expired, &testClaims{}), ErrExpired)
+
+	token, err := ks.Sign(testClaims{RegisteredClaims: RegisteredClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}, Name: "Alice"})
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":9999999999,"name":"Mallory"}`))
+	assert.ErrorIs(t, ks.Verify(parts[0]+"."+forged+"."+parts[2], &testClaims{}), ErrInvalidToken)
+}
+
+func TestVerifyRejectsAlgorithmMismatch(t *testing.T) {
+	ks := newTestKeySet(t, "h1")
+	token, err := ks.Sign(testClaims{RegisteredClaims: RegisteredClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}})
+	assert.NoError(t, err)
+
+	// Re-label the HS256 header as EdDSA while keeping the HMAC signature.
+	parts := strings.Split(token, ".")
+	relabelled := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT","kid":"h1"}`))
+	assert.ErrorIs(t, ks.Verify(relabelled+"."+parts[1]+"."+parts[2], &testClaims{}), ErrInvalidToken)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := NewHMACKey("2024", bytes.Repeat([]byte("o"), 32))
+	assert.NoError(t, err)
+	newKey, err := NewHMACKey("2025", bytes.Repeat([]byte("n"), 32))
+	assert.NoError(t, err)
+
+	before, err := NewKeySet("2024", oldKey)
+	assert.NoError(t, err)
+	token, err := before.Sign(testClaims{RegisteredClaims: RegisteredClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}})
+	assert.NoError(t, err)
+
+	// After rotation tokens signed with the old key must still verify.
+	after, err := NewKeySet("2025", oldKey, newKey)
+	assert.NoError(t, err)
+	assert.NoError(t, after.Verify(token, &testClaims{}))
+
+	// Once the old key is retired they must not.
+	retired, err := NewKeySet("2025", newKey)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, retired.Verify(token, &testClaims{}), ErrUnknownKey)
+}
+
+func TestParseKey(t *testing.T) {
+	secret := base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))
+
+	key, err := ParseKey(HS256, "primary:"+secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", key.ID)
+
+	_, err = ParseKey(HS256, "short:"+base64.RawURLEncoding.EncodeToString([]byte("tiny")))
+	assert.Error(t, err)
+
+	_, err = ParseKey(EdDSA, "missing-separator")
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Links builds the absolute URLs that emails point to. Templates call it
+// through the frontendURL and apiURL functions, so no template hardcodes a
+// host.
+type Links struct {
+	// FrontendURL is where the web app is served, such as
+	// https://app.example.com.
+	FrontendURL string
+	// APIURL is where this API is served, for links that download straight
+	// from it.
+	APIURL string
+}
+
+// DefaultLinks points at a frontend and API running locally.
+var DefaultLinks = Links{
+	FrontendURL: "http://localhost:3000",
+	APIURL:      "http://localhost:4000",
+}
+
+// Frontend returns the URL of a page in the web app. The app uses hash
+// routing, so path goes after "/#". params are name and value pairs added
+// as the query string.
+func (l Links) Frontend(path string, params ...string) string {
+	return strings.TrimRight(l.FrontendURL, "/") + "/#" + path + query(params)
+}
+
+// API returns the URL of an API endpoint, with params added as in Frontend.
+func (l Links) API(path string, params ...string) string {
+	return strings.TrimRight(l.APIURL, "/") + path + query(params)
+}
+
+func query(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for i := 0; i+1 < len(params); i += 2 {
+		values.Add(params[i], params[i+1])
+	}
+	return "?" + values.Encode()
+}
+
+// weekdayNames holds the names of the days of the week, starting with
+// Sunday, for locales other than English.
+var weekdayNames = map[string][7]string{
+	"es": {"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+}
+
+// weekdayName returns the name of t's day of the week in locale, falling
+// back to English.
+func weekdayName(locale string, t time.Time) string {
+	names, ok := weekdayNames[locale]
+	if !ok {
+		return t.Weekday().String()
+	}
+	return names[t.Weekday()]
+}
+
+// shortWeekdayName is weekdayName cut to its first three letters.
+func shortWeekdayName(locale string, t time.Time) string {
+	name := []rune(weekdayName(locale, t))
+	return string(name[:min(3, len(name))])
+}
@@ -1,71 +1,142 @@
+// Package mailer renders the email templates and hands the result to a
+// Sender, which delivers it over SMTP, writes it to disk, logs it or keeps it
+// in memory for tests.
+//
+// Templates are localized: templates/<locale>/<name>.tmpl, where locale is a
+// language tag such as "es" or "pt-BR". DefaultLocale has every template,
+// and is used for any template a locale doesn't have.
 package mailer
 
 import (
 	"bytes"
 	"embed"
 	"html/template"
+	"io/fs"
+	"path"
+	"strings"
 	"time"
-
-	"github.com/go-mail/mail/v2"
 )
 
 //go:embed "templates"
 var templateFS embed.FS
 
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// DefaultLocale is the locale used when a user's locale, or its language,
+// has no translation of a template.
+const DefaultLocale = "en"
+
+// Sender delivers a rendered email.
+type Sender interface {
+	Send(msg *Message) error
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+type Mailer struct {
+	sender Sender
+	from   string
+	links  Links
+}
 
+// New returns a mailer that delivers with sender, from the address from,
+// with links in emails built by links.
+func New(sender Sender, from string, links Links) Mailer {
 	return Mailer{
-		dialer: dialer,
 		sender: sender,
+		from:   from,
+		links:  links,
+	}
+}
+
+// Message is an email rendered from a template.
+type Message struct {
+	To        string `json:"to,omitempty"`
+	From      string `json:"from,omitempty"`
+	Subject   string `json:"subject"`
+	PlainBody string `json:"plain_body"`
+	HTMLBody  string `json:"html_body"`
+	// Locale is the locale of the template that was used, which is
+	// DefaultLocale if there was no translation.
+	Locale string `json:"locale"`
+	// Template and Data are what the message was rendered from, so tests
+	// can check them without parsing the bodies.
+	Template string      `json:"-"`
+	Data     interface{} `json:"-"`
+}
+
+// templatePath finds templateFile for locale, trying the locale itself,
+// then its language without the region, then DefaultLocale. It returns the
+// locale it found.
+func templatePath(locale, templateFile string) (string, string) {
+	candidates := []string{locale}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, language)
+	}
+	for _, candidate := range candidates {
+		if candidate == "" || strings.ContainsAny(candidate, "./") {
+			continue
+		}
+		p := path.Join("templates", candidate, templateFile)
+		if _, err := fs.Stat(templateFS, p); err == nil {
+			return p, candidate
+		}
 	}
+	return path.Join("templates", DefaultLocale, templateFile), DefaultLocale
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// funcs returns the functions available to templates in locale.
+func (m Mailer) funcs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"frontendURL":  m.links.Frontend,
+		"apiURL":       m.links.API,
+		"weekday":      func(t time.Time) string { return weekdayName(locale, t) },
+		"shortWeekday": func(t time.Time) string { return shortWeekdayName(locale, t) },
+	}
+}
+
+// Render executes the subject, plainBody and htmlBody templates in
+// templateFile, translated for locale, without sending anything.
+func (m Mailer) Render(locale, templateFile string, data interface{}) (*Message, error) {
+	file, locale := templatePath(locale, templateFile)
+	tmpl, err := template.New("email").Funcs(m.funcs(locale)).ParseFS(templateFS, file)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Try sending the email up to three times.
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if err == nil {
-			return nil
-		}
-		time.Sleep(500 * time.Millisecond)
+	return &Message{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+		Locale:    locale,
+		Template:  templateFile,
+		Data:      data,
+	}, nil
+}
+
+// Send renders templateFile with data, in the recipient's locale, and
+// delivers it to recipient.
+func (m Mailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	msg, err := m.Render(locale, templateFile, data)
+	if err != nil {
+		return err
 	}
+	msg.To = recipient
+	msg.From = m.from
 
-	return err
-}
\ No newline at end of file
+	return m.sender.Send(msg)
+}
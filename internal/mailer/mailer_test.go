@@ -0,0 +1,149 @@
+package mailer
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := &Recorder{}
+	m := New(recorder, "Feel Flow <no-reply@example.com>", DefaultLinks)
+
+	err := m.Send("alice@example.com", "en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"})
+	assert.NoError(t, err)
+	err = m.Send("bob@example.com", "en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "ZYXWVUTSRQPONMLKJIHGFEDCBA"})
+	assert.NoError(t, err)
+
+	assert.Len(t, recorder.Messages(), 2)
+	sent := recorder.SentTo("alice@example.com")
+	if assert.Len(t, sent, 1) {
+		msg := sent[0]
+		assert.Equal(t, "Feel Flow <no-reply@example.com>", msg.From)
+		assert.Equal(t, "user_welcome.tmpl", msg.Template)
+		assert.Equal(t, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", msg.Data.(map[string]interface{})["activationToken"])
+		assert.Contains(t, msg.PlainBody, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	}
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Messages())
+
+	recorder.Err = errors.New("mailbox full")
+	assert.ErrorIs(t, m.Send("alice@example.com", "en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "TOKEN"}), recorder.Err)
+	assert.Empty(t, recorder.Messages())
+}
+
+func TestFileSender(t *testing.T) {
+	t.Run("eml", func(t *testing.T) {
+		dir := t.TempDir()
+		m := New(&FileSender{Dir: dir}, "no-reply@example.com", DefaultLinks)
+		assert.NoError(t, m.Send("alice@example.com", "en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "TOKEN"}))
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+		assert.NoError(t, err)
+		if assert.Len(t, files, 1) {
+			contents, err := os.ReadFile(files[0])
+			assert.NoError(t, err)
+			assert.Contains(t, string(contents), "To: alice@example.com")
+			assert.Contains(t, string(contents), "text/html")
+		}
+	})
+
+	t.Run("maildir", func(t *testing.T) {
+		dir := t.TempDir()
+		m := New(&FileSender{Dir: dir, Maildir: true}, "no-reply@example.com", DefaultLinks)
+		assert.NoError(t, m.Send("alice@example.com", "en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "TOKEN"}))
+		assert.NoError(t, m.Send("bob@example.com", "en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "TOKEN"}))
+
+		for sub, want := range map[string]int{"new": 2, "tmp": 0, "cur": 0} {
+			entries, err := os.ReadDir(filepath.Join(dir, sub))
+			assert.NoError(t, err)
+			assert.Len(t, entries, want, sub)
+			for _, entry := range entries {
+				assert.False(t, strings.HasSuffix(entry.Name(), ".eml"))
+			}
+		}
+	})
+}
+
+func TestTemplatesInEveryLocale(t *testing.T) {
+	names, err := fs.Glob(templateFS, "templates/"+DefaultLocale+"/*.tmpl")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, names)
+
+	locales, err := fs.ReadDir(templateFS, "templates")
+	assert.NoError(t, err)
+
+	m := New(&Recorder{}, "no-reply@example.com", DefaultLinks)
+	for _, locale := range locales {
+		for _, name := range names {
+			file := path.Join("templates", locale.Name(), path.Base(name))
+			t.Run(file, func(t *testing.T) {
+				tmpl, err := template.New("email").Funcs(m.funcs(locale.Name())).ParseFS(templateFS, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, block := range []string{"subject", "plainBody", "htmlBody"} {
+					assert.NotNil(t, tmpl.Lookup(block), "%s does not define %q", file, block)
+				}
+			})
+		}
+	}
+}
+
+func TestLocaleFallback(t *testing.T) {
+	m := New(&Recorder{}, "no-reply@example.com", DefaultLinks)
+	data := map[string]interface{}{"activationToken": "TOKEN"}
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en", "en"},
+		{"es", "es"},
+		{"es-MX", "es"},
+		{"fr", "en"},
+		{"", "en"},
+		{"../en", "en"},
+	}
+	for _, tt := range tests {
+		msg, err := m.Render(tt.locale, "user_welcome.tmpl", data)
+		if assert.NoError(t, err, tt.locale) {
+			assert.Equal(t, tt.want, msg.Locale, tt.locale)
+		}
+	}
+
+	msg, err := m.Render("es", "user_welcome.tmpl", data)
+	assert.NoError(t, err)
+	assert.Contains(t, msg.Subject, "bienvenida")
+}
+
+func TestLinks(t *testing.T) {
+	links := Links{FrontendURL: "https://app.example.com/", APIURL: "https://api.example.com"}
+	assert.Equal(t, "https://app.example.com/#/login", links.Frontend("/login"))
+	assert.Equal(t, "https://app.example.com/#/activate?token=A%2BB", links.Frontend("/activate", "token", "A+B"))
+	assert.Equal(t, "https://api.example.com/v1/exports/download?token=T", links.API("/v1/exports/download", "token", "T"))
+
+	m := New(&Recorder{}, "no-reply@example.com", links)
+	msg, err := m.Render("en", "user_welcome.tmpl", map[string]interface{}{"activationToken": "TOKEN"})
+	assert.NoError(t, err)
+	assert.Contains(t, msg.PlainBody, "https://app.example.com/#/activate?token=TOKEN")
+	assert.NotContains(t, msg.HTMLBody, "localhost")
+}
+
+func TestWeekdayNames(t *testing.T) {
+	sunday := time.Date(2024, time.June, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Sunday", weekdayName("en", sunday))
+	assert.Equal(t, "Sun", shortWeekdayName("en", sunday))
+	assert.Equal(t, "domingo", weekdayName("es", sunday))
+	assert.Equal(t, "mié", shortWeekdayName("es", sunday.AddDate(0, 0, 3)))
+	assert.Equal(t, "Sunday", weekdayName("fr", sunday))
+}
@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// mime converts msg to a multipart message with plain text and HTML parts.
+func (msg *Message) mime() *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
+
+// SMTPSender delivers email through an SMTP server. Each Send makes a single
+// attempt; emails sent through the job queue are retried with its backoff.
+type SMTPSender struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTPSender returns a sender for the SMTP server at host:port.
+func NewSMTPSender(host string, port int, username, password string) *SMTPSender {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+	return &SMTPSender{dialer: dialer}
+}
+
+func (s *SMTPSender) Send(msg *Message) error {
+	return s.dialer.DialAndSend(msg.mime())
+}
+
+// FileSender writes each email to its own file in Dir, for development
+// without an SMTP server. With Maildir set, Dir is a Maildir that mail
+// clients can open; otherwise the files are .eml files that most clients can
+// open directly.
+type FileSender struct {
+	Dir     string
+	Maildir bool
+}
+
+func (s *FileSender) Send(msg *Message) error {
+	name := fmt.Sprintf("%d.%s", time.Now().UnixNano(), rand.Text())
+
+	dir := s.Dir
+	if s.Maildir {
+		// Maildir readers only look in new/, and expect files to appear
+		// there whole, so write to tmp/ first and move it.
+		dir = filepath.Join(s.Dir, "tmp")
+	} else {
+		name += ".eml"
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = msg.mime().WriteTo(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	if !s.Maildir {
+		return nil
+	}
+	for _, sub := range []string{"new", "cur"} {
+		err = os.MkdirAll(filepath.Join(s.Dir, sub), 0o755)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(path, filepath.Join(s.Dir, "new", name))
+}
+
+// LogSender logs each email instead of sending it, plain text body
+// included, so links such as activation links can be copied from the logs.
+type LogSender struct {
+	Logger *slog.Logger
+}
+
+func (s *LogSender) Send(msg *Message) error {
+	s.Logger.Info("email not sent (log mail backend)", "to", msg.To, "template", msg.Template, "subject", msg.Subject, "body", msg.PlainBody)
+	return nil
+}
+
+// Recorder keeps every email in memory, for tests to inspect. It is safe to
+// use from several goroutines.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []*Message
+	// Err, if set, is returned by Send instead of recording the message.
+	Err error
+}
+
+func (r *Recorder) Send(msg *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return r.Err
+	}
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// Messages returns the emails sent so far, oldest first.
+func (r *Recorder) Messages() []*Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.messages)
+}
+
+// SentTo returns the emails sent to recipient, oldest first.
+func (r *Recorder) SentTo(recipient string) []*Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sent []*Message
+	for _, msg := range r.messages {
+		if msg.To == recipient {
+			sent = append(sent, msg)
+		}
+	}
+	return sent
+}
+
+// Reset forgets the recorded emails.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = nil
+}
@@ -0,0 +1,245 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClientID     = "feel-flow"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:3000/#/oidc/callback"
+)
+
+// fakeProvider is an in-process identity provider. It issues an ID token for
+// whatever claims are set when the code is exchanged.
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	claims map[string]any
+
+	jwksRequests int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	f := &fakeProvider{t: t, kid: "key-1"}
+	var err error
+	f.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		f.writeJSON(w, map[string]string{
+			"issuer":                 f.server.URL,
+			"authorization_endpoint": f.server.URL + "/authorize",
+			"token_endpoint":         f.server.URL + "/token",
+			"jwks_uri":               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.jwksRequests++
+		pub := f.key.PublicKey
+		f.writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": f.kid,
+			"use": "sig",
+			"n":   b64.EncodeToString(pub.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != testClientID || secret != testClientSecret || r.PostFormValue("code") != "good-code" || r.PostFormValue("redirect_uri") != testRedirectURL {
+			w.WriteHeader(http.StatusBadRequest)
+			f.writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		f.writeJSON(w, map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": f.sign(f.claims)})
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	now := time.Now().Unix()
+	f.claims = map[string]any{
+		"iss":            f.server.URL,
+		"sub":            "user-123",
+		"aud":            testClientID,
+		"iat":            now,
+		"exp":            now + 300,
+		"nonce":          "the-nonce",
+		"email":          "joana@example.com",
+		"email_verified": true,
+		"name":           "Joana",
+	}
+	return f
+}
+
+func (f *fakeProvider) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	assert.NoError(f.t, json.NewEncoder(w).Encode(v))
+}
+
+func (f *fakeProvider) sign(claims map[string]any) string {
+	h, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": f.kid})
+	assert.NoError(f.t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(f.t, err)
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	assert.NoError(f.t, err)
+	return input + "." + b64.EncodeToString(sig)
+}
+
+func (f *fakeProvider) provider() *Provider {
+	p, err := NewProvider(Config{
+		Issuer:       f.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"email", "profile"},
+	}, f.server.Client())
+	assert.NoError(f.t, err)
+	return p
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	f := newFakeProvider(t)
+	authURL, err := f.provider().AuthCodeURL(context.Background(), "the-state", "the-nonce")
+	assert.NoError(t, err)
+
+	u, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, f.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	q := u.Query()
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, testClientID, q.Get("client_id"))
+	assert.Equal(t, testRedirectURL, q.Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", q.Get("scope"))
+	assert.Equal(t, "the-state", q.Get("state"))
+	assert.Equal(t, "the-nonce", q.Get("nonce"))
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+
+	claims, err := p.Exchange(context.Background(), "good-code", "the-nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-123", claims.Subject)
+	assert.Equal(t, "joana@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	_, err = p.Exchange(context.Background(), "bad-code", "the-nonce")
+	assert.ErrorIs(t, err, ErrExchange)
+
+	_, err = p.Exchange(context.Background(), "good-code", "another-nonce")
+	assert.ErrorIs(t, err, ErrNonceMismatch)
+}
+
+func TestVerifyIDTokenRejectsBadClaims(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	for name, tc := range map[string]struct {
+		key   string
+		value any
+		want  error
+	}{
+		"wrong issuer":     {"iss", "https://evil.example", ErrInvalidToken},
+		"wrong audience":   {"aud", "someone-else", ErrInvalidToken},
+		"foreign azp":      {"aud", []string{testClientID, "someone-else"}, ErrInvalidToken},
+		"expired":          {"exp", time.Now().Add(-time.Hour).Unix(), ErrExpired},
+		"issued in future": {"iat", time.Now().Add(time.Hour).Unix(), ErrInvalidToken},
+		"missing nonce":    {"nonce", "", ErrNonceMismatch},
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims := map[string]any{}
+			for k, v := range f.claims {
+				claims[k] = v
+			}
+			claims[tc.key] = tc.value
+			_, err := p.VerifyIDToken(ctx, f.sign(claims), "the-nonce")
+			assert.ErrorIs(t, err, tc.want)
+		})
+	}
+
+	// The audience may be a list as long as azp names this client, and some
+	// providers send email_verified as a string.
+	claims := map[string]any{}
+	for k, v := range f.claims {
+		claims[k] = v
+	}
+	claims["aud"] = []string{testClientID, "someone-else"}
+	claims["azp"] = testClientID
+	claims["email_verified"] = "true"
+	verified, err := p.VerifyIDToken(ctx, f.sign(claims), "the-nonce")
+	assert.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+}
+
+func TestVerifyIDTokenRejectsBadSignature(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	token := f.sign(f.claims)
+	tampered := token[:len(token)-4] + "AAAA"
+	_, err := p.VerifyIDToken(ctx, tampered, "the-nonce")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// A token signed by another key under the same key ID is rejected.
+	impostor := newFakeProvider(t)
+	_, err = p.VerifyIDToken(ctx, impostor.sign(f.claims), "the-nonce")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// "none" can't be used to skip the signature.
+	h, _ := json.Marshal(map[string]string{"alg": "none", "kid": f.kid})
+	payload, _ := json.Marshal(f.claims)
+	_, err = p.VerifyIDToken(ctx, b64.EncodeToString(h)+"."+b64.EncodeToString(payload)+".", "the-nonce")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestKeyRotation(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	_, err := p.VerifyIDToken(ctx, f.sign(f.claims), "the-nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.jwksRequests)
+
+	// Cached keys are reused.
+	_, err = p.VerifyIDToken(ctx, f.sign(f.claims), "the-nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.jwksRequests)
+
+	// The provider rotates its key. The key set is refetched for the new key
+	// ID, but not more than once a minute.
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	f.key, f.kid = rotated, "key-2"
+	_, err = p.VerifyIDToken(ctx, f.sign(f.claims), "the-nonce")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, f.jwksRequests)
+
+	p.keysFetched = time.Now().Add(-minKeyRefresh)
+	_, err = p.VerifyIDToken(ctx, f.sign(f.claims), "the-nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, f.jwksRequests)
+}
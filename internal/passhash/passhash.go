@@ -0,0 +1,67 @@
+// Package passhash hashes and verifies user passwords. New hashes are made
+// with a primary scheme (Argon2id by default), while hashes made by older
+// schemes or with weaker parameters still verify and are flagged for rehashing.
+package passhash
+
+import (
+	"errors"
+)
+
+// ErrUnknownScheme is returned when no configured scheme recognises a hash.
+var ErrUnknownScheme = errors.New("passhash: unknown hash scheme")
+
+// Scheme is a single password hashing algorithm.
+type Scheme interface {
+	// Hash returns the encoded hash of plaintext, including its parameters.
+	Hash(plaintext string) (string, error)
+	// Verify reports whether plaintext matches the encoded hash.
+	Verify(encoded, plaintext string) (bool, error)
+	// Identify reports whether encoded was produced by this scheme.
+	Identify(encoded string) bool
+	// NeedsRehash reports whether encoded uses weaker parameters than the scheme is configured with.
+	NeedsRehash(encoded string) bool
+	// MaxLength is the longest password in bytes the scheme accepts.
+	MaxLength() int
+}
+
+// Hasher hashes with its primary scheme and verifies with any of its schemes.
+type Hasher struct {
+	primary Scheme
+	schemes []Scheme
+}
+
+// NewHasher creates a Hasher which hashes new passwords with primary and can
+// still verify hashes made with any of the legacy schemes.
+func NewHasher(primary Scheme, legacy ...Scheme) *Hasher {
+	return &Hasher{
+		primary: primary,
+		schemes: append([]Scheme{primary}, legacy...),
+	}
+}
+
+// Hash hashes plaintext with the primary scheme.
+func (h *Hasher) Hash(plaintext string) (string, error) {
+	return h.primary.Hash(plaintext)
+}
+
+// Verify checks plaintext against encoded. needsRehash is true when the
+// password matched but the hash was made by a legacy scheme or with weaker
+// parameters than the primary scheme now uses.
+func (h *Hasher) Verify(encoded, plaintext string) (match bool, needsRehash bool, err error) {
+	for _, scheme := range h.schemes {
+		if !scheme.Identify(encoded) {
+			continue
+		}
+		match, err = scheme.Verify(encoded, plaintext)
+		if err != nil || !match {
+			return false, false, err
+		}
+		return true, scheme != h.primary || scheme.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownScheme
+}
+
+// MaxLength is the longest password the primary scheme accepts.
+func (h *Hasher) MaxLength() int {
+	return h.primary.MaxLength()
+}
@@ -0,0 +1,82 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2id keeps the tests fast; production uses DefaultArgon2id.
+var testArgon2id = Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	encoded, err := testArgon2id.Hash("pa55word")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	match, err := testArgon2id.Verify(encoded, "pa55word")
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	match, err = testArgon2id.Verify(encoded, "wrong password")
+	assert.NoError(t, err)
+	assert.False(t, match)
+
+	// The same password hashes differently every time because of the salt.
+	again, err := testArgon2id.Hash("pa55word")
+	assert.NoError(t, err)
+	assert.NotEqual(t, encoded, again)
+
+	_, err = testArgon2id.Verify("$argon2id$v=19$garbage", "pa55word")
+	assert.Error(t, err)
+}
+
+func TestHasherRehashesLegacyAndWeakHashes(t *testing.T) {
+	legacy := Bcrypt{Cost: bcrypt.MinCost}
+	hasher := NewHasher(testArgon2id, legacy)
+
+	// A bcrypt hash matches but should be upgraded.
+	bcryptHash, err := legacy.Hash("pa55word")
+	assert.NoError(t, err)
+	match, needsRehash, err := hasher.Verify(bcryptHash, "pa55word")
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	// A current hash doesn't need upgrading.
+	current, err := hasher.Hash("pa55word")
+	assert.NoError(t, err)
+	match, needsRehash, err = hasher.Verify(current, "pa55word")
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, needsRehash)
+
+	// Raising the parameters flags the old hash for rehashing.
+	stronger := testArgon2id
+	stronger.Iterations = 2
+	match, needsRehash, err = NewHasher(stronger, legacy).Verify(current, "pa55word")
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, needsRehash)
+
+	// A wrong password never asks for a rehash.
+	match, needsRehash, err = hasher.Verify(bcryptHash, "wrong password")
+	assert.NoError(t, err)
+	assert.False(t, match)
+	assert.False(t, needsRehash)
+
+	_, _, err = hasher.Verify("$1$md5crypt", "pa55word")
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+}
+
+func TestBcryptLongPasswordIsMismatch(t *testing.T) {
+	legacy := Bcrypt{Cost: bcrypt.MinCost}
+	hash, err := legacy.Hash("pa55word")
+	assert.NoError(t, err)
+
+	match, err := legacy.Verify(hash, strings.Repeat("a", 100))
+	assert.NoError(t, err)
+	assert.False(t, match)
+}
@@ -0,0 +1,102 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRFC6238Vectors checks the test vectors from RFC 6238 Appendix B.
+func TestRFC6238Vectors(t *testing.T) {
+	secrets := map[Algorithm][]byte{
+		SHA1:   []byte("12345678901234567890"),
+		SHA256: []byte("12345678901234567890123456789012"),
+		SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+
+	tests := []struct {
+		unix int64
+		alg  Algorithm
+		code string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{1111111111, SHA1, "14050471"},
+		{1111111111, SHA256, "67062674"},
+		{1111111111, SHA512, "99943326"},
+		{1234567890, SHA1, "89005924"},
+		{1234567890, SHA256, "91819424"},
+		{1234567890, SHA512, "93441116"},
+		{2000000000, SHA1, "69279037"},
+		{2000000000, SHA256, "90698825"},
+		{2000000000, SHA512, "38618901"},
+		{20000000000, SHA1, "65353130"},
+		{20000000000, SHA256, "77737706"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for _, tt := range tests {
+		opts := Options{Period: 30 * time.Second, Digits: 8, Algorithm: tt.alg}
+		got := opts.Generate(secrets[tt.alg], time.Unix(tt.unix, 0))
+		assert.Equal(t, tt.code, got, "time %d, %s", tt.unix, tt.alg)
+	}
+}
+
+// TestRFC4226Vectors checks the HOTP test vectors from RFC 4226 Appendix D.
+func TestRFC4226Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range expected {
+		assert.Equal(t, code, HOTP(secret, uint64(counter), 6, SHA1), "counter %d", counter)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+
+	now := time.Unix(1_700_000_000, 0)
+	code := DefaultOptions.Generate(secret, now)
+
+	step, ok := DefaultOptions.Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, DefaultOptions.Step(now), step)
+
+	// One period of clock drift either way is tolerated, two is not.
+	_, ok = DefaultOptions.Validate(secret, code, now.Add(30*time.Second))
+	assert.True(t, ok)
+	_, ok = DefaultOptions.Validate(secret, code, now.Add(-30*time.Second))
+	assert.True(t, ok)
+	_, ok = DefaultOptions.Validate(secret, code, now.Add(90*time.Second))
+	assert.False(t, ok)
+
+	_, ok = DefaultOptions.Validate(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestSecretEncodingAndURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	encoded := EncodeSecret(secret)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", encoded)
+
+	decoded, err := DecodeSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	assert.NoError(t, err)
+	assert.Equal(t, secret, decoded)
+
+	u, err := url.Parse(DefaultOptions.URI("Feel Flow", "alice@example.com", secret))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Feel Flow:alice@example.com", u.Path)
+	assert.Equal(t, encoded, u.Query().Get("secret"))
+	assert.Equal(t, "Feel Flow", u.Query().Get("issuer"))
+}
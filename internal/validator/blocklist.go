@@ -0,0 +1,136 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+//go:generate go run gen_blocklist.go
+
+var (
+	//go:embed wordlists/common_words.txt
+	commonWordsFile []byte
+
+	//go:embed wordlists/breached_passwords.bloom
+	breachedPasswordsFile []byte
+)
+
+// commonWords holds the dictionary PasswordStrength looks for inside passwords.
+var commonWords = loadWordList(commonWordsFile)
+
+// breachedPasswords is a bloom filter of passwords known from public breaches.
+var breachedPasswords = mustLoadBloomFilter(breachedPasswordsFile)
+
+// IsBreachedPassword reports whether password (in any case) appears on the
+// embedded list of commonly breached passwords. Like any bloom filter it can
+// give rare false positives but never false negatives.
+func IsBreachedPassword(password string) bool {
+	return breachedPasswords.Contains(strings.ToLower(password))
+}
+
+// loadWordList parses a newline-separated list, skipping blanks and # comments.
+func loadWordList(file []byte) map[string]bool {
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words[strings.ToLower(word)] = true
+	}
+	return words
+}
+
+func mustLoadBloomFilter(file []byte) *BloomFilter {
+	f := new(BloomFilter)
+	if err := f.UnmarshalBinary(file); err != nil {
+		panic(err)
+	}
+	return f
+}
+
+var bloomMagic = [4]byte{'F', 'F', 'B', 'L'}
+
+// ErrInvalidBloomFilter is returned when unmarshalling malformed filter data.
+var ErrInvalidBloomFilter = errors.New("validator: invalid bloom filter data")
+
+// BloomFilter is a compact set membership test. It is used to ship a large
+// password blocklist inside the binary for a fraction of its plain size.
+type BloomFilter struct {
+	hashes uint32
+	bits   []byte
+}
+
+// NewBloomFilter sizes a filter for n entries at the given false positive rate.
+func NewBloomFilter(n int, falsePositiveRate float64) *BloomFilter {
+	n = max(n, 1)
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return &BloomFilter{
+		hashes: uint32(max(k, 1)),
+		bits:   make([]byte, (int(m)+7)/8),
+	}
+}
+
+// locations uses double hashing to derive the filter's bit positions for s.
+func (f *BloomFilter) locations(s string) []uint64 {
+	sum := sha256.Sum256([]byte(s))
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	m := uint64(len(f.bits)) * 8
+
+	locations := make([]uint64, f.hashes)
+	for i := range locations {
+		locations[i] = (h1 + uint64(i)*h2) % m
+	}
+	return locations
+}
+
+// Add inserts s into the filter.
+func (f *BloomFilter) Add(s string) {
+	for _, loc := range f.locations(s) {
+		f.bits[loc/8] |= 1 << (loc % 8)
+	}
+}
+
+// Contains reports whether s may have been added to the filter.
+func (f *BloomFilter) Contains(s string) bool {
+	if len(f.bits) == 0 {
+		return false
+	}
+	for _, loc := range f.locations(s) {
+		if f.bits[loc/8]&(1<<(loc%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary encodes the filter as a magic number, the hash count and the bit array.
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 8, 8+len(f.bits))
+	copy(out, bloomMagic[:])
+	binary.BigEndian.PutUint32(out[4:8], f.hashes)
+	return append(out, f.bits...), nil
+}
+
+// UnmarshalBinary decodes a filter written by MarshalBinary.
+func (f *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 9 || !bytes.Equal(data[:4], bloomMagic[:]) {
+		return ErrInvalidBloomFilter
+	}
+	hashes := binary.BigEndian.Uint32(data[4:8])
+	if hashes == 0 || hashes > 64 {
+		return ErrInvalidBloomFilter
+	}
+	f.hashes = hashes
+	f.bits = bytes.Clone(data[8:])
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build ignore
+
+// gen_blocklist builds wordlists/breached_passwords.bloom from
+// wordlists/common_passwords.txt. To block more passwords, append them to the
+// text file (or point -in at a larger list) and run:
+//
+//	go generate ./internal/validator
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"feel-flow-api/internal/validator"
+)
+
+func main() {
+	in := flag.String("in", "wordlists/common_passwords.txt", "newline-separated password list")
+	out := flag.String("out", "wordlists/breached_passwords.bloom", "output file")
+	rate := flag.Float64("fp-rate", 0.001, "target false positive rate")
+	flag.Parse()
+
+	file, err := os.Open(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		password := strings.TrimSpace(scanner.Text())
+		if password == "" || strings.HasPrefix(password, "#") {
+			continue
+		}
+		passwords = append(passwords, strings.ToLower(password))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	filter := validator.NewBloomFilter(len(passwords), *rate)
+	for _, password := range passwords {
+		filter.Add(password)
+	}
+
+	data, err := filter.MarshalBinary()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d passwords to %s (%d bytes)", len(passwords), *out, len(data))
+}
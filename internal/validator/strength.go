@@ -0,0 +1,279 @@
+package validator
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MinPasswordScore is the lowest PasswordStrength score accepted for a new password.
+const MinPasswordScore = 2
+
+// Strength is the result of estimating how hard a password is to guess.
+type Strength struct {
+	Score    int      // 0 (very weak) to 4 (very strong).
+	Entropy  float64  // Estimated bits of entropy after patterns are discounted.
+	Feedback []string // Suggestions for making the password stronger.
+}
+
+// Each weak pattern found in a password is charged a fixed number of bits
+// instead of the full cost of its characters, roughly the log2 of how many
+// guesses an attacker trying that kind of pattern would need.
+const (
+	dictionaryWordBits = 11
+	userInputBits      = 4
+	keyboardRunBits    = 6
+	sequenceBits       = 5
+	repeatBits         = 4
+	yearBits           = 7
+)
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik,9ol.0p;/-['=]",
+	"qazwsxedcrfvtgbyhnujmikolp",
+}
+
+// leetReplacer undoes common character substitutions before dictionary lookups.
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "l", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "9", "g",
+	"@", "a", "$", "s", "!", "i", "+", "t", "|", "l",
+)
+
+var yearRX = regexp.MustCompile(`(19|20)\d\d`)
+
+// segment marks a weak pattern covering password runes [start, end).
+type segment struct {
+	start, end int
+	bits       float64
+}
+
+// PasswordStrength estimates the strength of password. It looks for common
+// dictionary words (including l33t spellings), keyboard patterns, sequences,
+// repeats, years and any of userInputs (such as the user's name or email), and
+// charges each a small fixed cost instead of the full cost of its characters.
+func PasswordStrength(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	unleet := []rune(leetReplacer.Replace(string(lower)))
+	if len(unleet) != len(lower) {
+		unleet = lower
+	}
+
+	var segments []segment
+	feedback := map[string]bool{}
+	add := func(start, end int, bits float64, message string) {
+		segments = append(segments, segment{start, end, bits})
+		feedback[message] = true
+	}
+
+	for _, token := range userInputTokens(userInputs) {
+		for _, candidate := range [][]rune{lower, unleet} {
+			for _, idx := range indexAll(string(candidate), token) {
+				add(idx, idx+len([]rune(token)), userInputBits, "don't include your name or email address")
+			}
+		}
+	}
+
+	for _, candidate := range [][]rune{lower, unleet} {
+		for start := 0; start < len(candidate); start++ {
+			for end := len(candidate); end-start >= 4; end-- {
+				if commonWords[string(candidate[start:end])] {
+					add(start, end, dictionaryWordBits, "avoid common words and names")
+					break
+				}
+			}
+		}
+	}
+
+	for start, end := range runs(lower, 4, isKeyboardStep) {
+		add(start, end, keyboardRunBits, "avoid keyboard patterns like \"qwerty\"")
+	}
+	for start, end := range runs(lower, 3, isSequenceStep) {
+		add(start, end, sequenceBits, "avoid sequences like \"abc\" or \"123\"")
+	}
+	for start, end := range runs(lower, 3, func(a, b rune) bool { return a == b }) {
+		add(start, end, repeatBits, "avoid repeated characters like \"aaa\"")
+	}
+	if start, end, ok := repeatedChunk(lower); ok {
+		add(start, end, repeatBits, "avoid repeating the same word or pattern")
+	}
+	for _, loc := range yearRX.FindAllStringIndex(string(lower), -1) {
+		start := len([]rune(string(lower)[:loc[0]]))
+		add(start, start+4, yearBits, "avoid years and dates")
+	}
+
+	// Each character is charged the cheapest pattern that covers it; the
+	// pattern's bits are spread evenly over its characters.
+	perRune := log2(charsetSize(runes))
+	cost := make([]float64, len(runes))
+	for i := range cost {
+		cost[i] = perRune
+	}
+	for _, seg := range segments {
+		share := seg.bits / float64(seg.end-seg.start)
+		for i := seg.start; i < seg.end && i < len(cost); i++ {
+			cost[i] = math.Min(cost[i], share)
+		}
+	}
+	entropy := 0.0
+	for _, c := range cost {
+		entropy += c
+	}
+
+	s := Strength{Entropy: entropy, Score: scoreForEntropy(entropy)}
+	for _, message := range []string{
+		"don't include your name or email address",
+		"avoid common words and names",
+		"avoid keyboard patterns like \"qwerty\"",
+		"avoid sequences like \"abc\" or \"123\"",
+		"avoid repeated characters like \"aaa\"",
+		"avoid repeating the same word or pattern",
+		"avoid years and dates",
+	} {
+		if feedback[message] {
+			s.Feedback = append(s.Feedback, message)
+		}
+	}
+	if s.Score < MinPasswordScore {
+		s.Feedback = append(s.Feedback, "add a few more uncommon words or make it longer")
+	}
+	return s
+}
+
+func scoreForEntropy(bits float64) int {
+	switch {
+	case bits < 25:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 50:
+		return 2
+	case bits < 65:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// charsetSize estimates the alphabet an attacker would need to brute force.
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return max(size, 1)
+}
+
+func log2(n int) float64 {
+	return math.Log2(float64(n))
+}
+
+// userInputTokens splits the user's own details into lowercase pieces of at
+// least three characters, e.g. "alice.jones@example.com" gives "alice", "jones".
+func userInputTokens(inputs []string) []string {
+	var tokens []string
+	for _, input := range inputs {
+		input = strings.ToLower(input)
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			input = local
+			tokens = append(tokens, local)
+		}
+		for _, field := range strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(field)) >= 3 {
+				tokens = append(tokens, field)
+			}
+		}
+	}
+	return tokens
+}
+
+// indexAll returns the rune offsets of every occurrence of sub in s.
+func indexAll(s, sub string) []int {
+	var offsets []int
+	for i := 0; ; {
+		j := strings.Index(s[i:], sub)
+		if j < 0 {
+			return offsets
+		}
+		offsets = append(offsets, len([]rune(s[:i+j])))
+		i += j + 1
+	}
+}
+
+// runs returns the [start, end) rune ranges of at least minLength runes where
+// every neighbouring pair satisfies step.
+func runs(s []rune, minLength int, step func(a, b rune) bool) map[int]int {
+	found := map[int]int{}
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i < len(s) && step(s[i-1], s[i]) {
+			continue
+		}
+		if i-start >= minLength {
+			found[start] = i
+		}
+		start = i
+	}
+	return found
+}
+
+// isKeyboardStep reports whether b is next to a on one of the keyboard rows, in either direction.
+func isKeyboardStep(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		if i < 0 {
+			continue
+		}
+		if (i+1 < len(row) && rune(row[i+1]) == b) || (i > 0 && rune(row[i-1]) == b) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSequenceStep reports whether b follows a alphabetically or numerically, in either direction.
+func isSequenceStep(a, b rune) bool {
+	sameClass := (unicode.IsLetter(a) && unicode.IsLetter(b)) || (unicode.IsDigit(a) && unicode.IsDigit(b))
+	return sameClass && (b-a == 1 || a-b == 1)
+}
+
+// repeatedChunk finds a password made of the same chunk repeated, like "abcabc".
+func repeatedChunk(s []rune) (int, int, bool) {
+	for size := 1; size <= len(s)/2; size++ {
+		if len(s)%size != 0 {
+			continue
+		}
+		chunk := string(s[:size])
+		if strings.Repeat(chunk, len(s)/size) == string(s) {
+			return size, len(s), true
+		}
+	}
+	return 0, 0, false
+}
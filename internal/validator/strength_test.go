@@ -0,0 +1,94 @@
+package validator
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordStrengthRejectsWeakPasswords(t *testing.T) {
+	tests := []struct {
+		password string
+		feedback string
+	}{
+		{"password1", "avoid common words and names"},
+		{"P@ssw0rd!", "avoid common words and names"},
+		{"qwertyuiop", "avoid keyboard patterns like \"qwerty\""},
+		{"abcdefgh123", "avoid sequences like \"abc\" or \"123\""},
+		{"zzzzzzzzzzzz", "avoid repeated characters like \"aaa\""},
+		{"xk2pxk2pxk2p", "avoid repeating the same word or pattern"},
+		{"sunshine1987", "avoid years and dates"},
+	}
+
+	for _, tt := range tests {
+		s := PasswordStrength(tt.password)
+		assert.Less(t, s.Score, MinPasswordScore, tt.password)
+		assert.Contains(t, s.Feedback, tt.feedback, tt.password)
+	}
+}
+
+func TestPasswordStrengthPenalizesUserInputs(t *testing.T) {
+	password := "Vanterpool!Okoye"
+	assert.GreaterOrEqual(t, PasswordStrength(password).Score, MinPasswordScore)
+
+	s := PasswordStrength(password, "Imani Vanterpool", "imani.okoye@example.com")
+	assert.Less(t, s.Score, MinPasswordScore)
+	assert.Contains(t, s.Feedback, "don't include your name or email address")
+}
+
+func TestPasswordStrengthAcceptsStrongPasswords(t *testing.T) {
+	for _, password := range []string{
+		"mq7Rz!vP2xLd",
+		"correct-horse-battery-staple",
+		"tundra gravel lantern kiwi",
+	} {
+		s := PasswordStrength(password)
+		assert.GreaterOrEqual(t, s.Score, MinPasswordScore, password)
+	}
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+	assert.True(t, IsBreachedPassword("password123"))
+	assert.True(t, IsBreachedPassword("QWERTY123"))
+	assert.False(t, IsBreachedPassword("mq7Rz!vP2xLd"))
+}
+
+func TestIsBreachedPasswordRejectsWellKnownPasswords(t *testing.T) {
+	for _, password := range []string{
+		"123456", "password", "12345678", "qwerty", "dragon", "baseball",
+		"football", "letmein", "monkey", "abc123", "mustang", "shadow",
+		"trustno1", "iloveyou", "sunshine", "princess", "starwars",
+		"whatever", "jennifer", "michelle", "corvette", "blink182",
+	} {
+		assert.True(t, IsBreachedPassword(password), password)
+	}
+}
+
+func TestBreachedPasswordsFilterMatchesList(t *testing.T) {
+	file, err := os.ReadFile("wordlists/common_passwords.txt")
+	assert.NoError(t, err)
+
+	passwords := loadWordList(file)
+	assert.Greater(t, len(passwords), 5000)
+	for password := range passwords {
+		if !IsBreachedPassword(password) {
+			t.Fatalf("%q is missing from the filter; run go generate ./internal/validator", password)
+		}
+	}
+}
+
+func TestBloomFilterRoundTrip(t *testing.T) {
+	f := NewBloomFilter(100, 0.01)
+	f.Add("hunter2")
+
+	data, err := f.MarshalBinary()
+	assert.NoError(t, err)
+
+	var g BloomFilter
+	assert.NoError(t, g.UnmarshalBinary(data))
+	assert.True(t, g.Contains("hunter2"))
+	assert.False(t, g.Contains("hunter3"))
+
+	assert.ErrorIs(t, g.UnmarshalBinary([]byte("nope")), ErrInvalidBloomFilter)
+}
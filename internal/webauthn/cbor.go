@@ -0,0 +1,172 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// errCBOR is returned for malformed or unsupported CBOR.
+var errCBOR = errors.New("webauthn: invalid CBOR")
+
+// maxCBORDepth bounds nesting so hostile input can't exhaust the stack.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first CBOR item in data and returns it along with the
+// number of bytes it used. It supports the subset WebAuthn needs: integers,
+// byte and text strings, arrays, maps, tags, booleans and null. Integers decode
+// to int64, maps to map[any]any keyed by int64 or string.
+func decodeCBOR(data []byte) (any, int, error) {
+	d := cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decode(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		b, err := d.take(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("%w: truncated array", errCBOR)
+		}
+		items := make([]any, arg)
+		for i := range items {
+			items[i], err = d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("%w: truncated map", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key type", errCBOR)
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("%w: duplicate map key", errCBOR)
+			}
+			m[key], err = d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		// Tags only add meaning to the item that follows; keep the item.
+		return d.decode(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, arg)
+	}
+}
+
+// head reads an item's initial byte and argument.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+	major, info := d.data[d.pos]>>5, d.data[d.pos]&0x1f
+	d.pos++
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		b, err := d.take(1 << (info - 24))
+		if err != nil {
+			return 0, 0, err
+		}
+		var arg uint64
+		for _, c := range b {
+			arg = arg<<8 | uint64(c)
+		}
+		if major == 7 && info > 24 {
+			return 0, 0, fmt.Errorf("%w: floating point values are not supported", errCBOR)
+		}
+		return major, arg, nil
+	default:
+		return 0, 0, fmt.Errorf("%w: indefinite lengths are not supported", errCBOR)
+	}
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// Helpers for reading decoded CBOR maps.
+
+func cborInt(m map[any]any, key any) (int64, bool) {
+	v, ok := m[key].(int64)
+	return v, ok
+}
+
+func cborBytes(m map[any]any, key any) ([]byte, bool) {
+	v, ok := m[key].([]byte)
+	return v, ok
+}
+
+func cborString(m map[any]any, key any) (string, bool) {
+	v, ok := m[key].(string)
+	return v, ok
+}
+
+func readUint32(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b)
+}
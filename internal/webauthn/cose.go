@@ -0,0 +1,106 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers accepted for credentials, most preferred first.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms lists the algorithms offered in creation options.
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9052 and RFC 9053).
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1 // Also the RSA modulus n.
+	coseX      = -2 // Also the RSA exponent e.
+	coseY      = -3
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// publicKey is a credential public key decoded from its COSE_Key form.
+type publicKey struct {
+	alg     int64
+	ecdsa   *ecdsa.PublicKey
+	ed25519 ed25519.PublicKey
+	rsa     *rsa.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key and returns it along with its encoded length.
+func parsePublicKey(raw []byte) (*publicKey, int, error) {
+	v, n, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: public key is not a map", ErrInvalidResponse)
+	}
+	kty, _ := cborInt(m, int64(coseKty))
+	alg, _ := cborInt(m, int64(coseAlg))
+
+	key := &publicKey{alg: alg}
+	switch {
+	case alg == AlgES256 && kty == ktyEC2:
+		crv, _ := cborInt(m, int64(coseCrv))
+		x, okX := cborBytes(m, int64(coseX))
+		y, okY := cborBytes(m, int64(coseY))
+		if crv != crvP256 || !okX || !okY || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: invalid P-256 key", ErrInvalidResponse)
+		}
+		point := append([]byte{4}, append(x, y...)...)
+		key.ecdsa, err = ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: invalid P-256 key", ErrInvalidResponse)
+		}
+	case alg == AlgEdDSA && kty == ktyOKP:
+		crv, _ := cborInt(m, int64(coseCrv))
+		x, okX := cborBytes(m, int64(coseX))
+		if crv != crvEd25519 || !okX || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: invalid Ed25519 key", ErrInvalidResponse)
+		}
+		key.ed25519 = ed25519.PublicKey(x)
+	case alg == AlgRS256 && kty == ktyRSA:
+		modulus, okN := cborBytes(m, int64(coseCrv))
+		exponent, okE := cborBytes(m, int64(coseX))
+		if !okN || !okE || len(modulus) < 256 || len(exponent) == 0 || len(exponent) > 4 {
+			return nil, 0, fmt.Errorf("%w: invalid RSA key", ErrInvalidResponse)
+		}
+		key.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+	default:
+		return nil, 0, fmt.Errorf("%w: kty %d, alg %d", ErrUnsupportedAlgorithm, kty, alg)
+	}
+	return key, n, nil
+}
+
+// verify checks sig over data.
+func (k *publicKey) verify(data, sig []byte) bool {
+	switch k.alg {
+	case AlgES256:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(k.ecdsa, digest[:], sig)
+	case AlgEdDSA:
+		return ed25519.Verify(k.ed25519, data, sig)
+	case AlgRS256:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
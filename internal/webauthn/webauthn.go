@@ -0,0 +1,421 @@
+// Package webauthn implements the relying party side of WebAuthn passkey
+// registration and authentication ceremonies. It supports "none" attestation
+// only, which is what browsers send unless attestation is requested, and
+// ES256, EdDSA and RS256 credential keys.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidResponse      = errors.New("webauthn: invalid response")
+	ErrChallengeMismatch    = errors.New("webauthn: challenge does not match")
+	ErrOriginMismatch       = errors.New("webauthn: origin is not allowed")
+	ErrRPIDMismatch         = errors.New("webauthn: relying party ID does not match")
+	ErrUserNotPresent       = errors.New("webauthn: user presence was not confirmed")
+	ErrUserNotVerified      = errors.New("webauthn: the authenticator did not verify the user")
+	ErrUnsupportedAlgorithm = errors.New("webauthn: unsupported public key algorithm")
+	ErrUnsupportedFormat    = errors.New("webauthn: unsupported attestation format")
+	ErrBadSignature         = errors.New("webauthn: signature verification failed")
+	ErrSignCount            = errors.New("webauthn: signature counter did not increase; the authenticator may have been cloned")
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagAttestedData   = 0x40
+)
+
+// ChallengeSize is the number of random bytes in a ceremony challenge.
+const ChallengeSize = 32
+
+// NewChallenge returns a fresh random challenge for one ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// URLEncodedBytes is a byte slice that is base64url encoded in JSON, the
+// form browsers use for binary fields in WebAuthn responses.
+type URLEncodedBytes []byte
+
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// Config describes the relying party (this API and its web frontend).
+type Config struct {
+	RPID    string        // Domain credentials are scoped to, e.g. "feelflow.app".
+	RPName  string        // Name shown by the authenticator.
+	Origins []string      // Origins ceremonies may come from, e.g. "https://feelflow.app".
+	Timeout time.Duration // How long the user has to complete a ceremony.
+}
+
+// RelyingParty creates ceremony options and verifies authenticator responses.
+type RelyingParty struct {
+	config Config
+	rpHash [32]byte
+}
+
+// New returns a RelyingParty for config.
+func New(config Config) (*RelyingParty, error) {
+	if config.RPID == "" {
+		return nil, errors.New("webauthn: relying party ID must be set")
+	}
+	if len(config.Origins) == 0 {
+		return nil, errors.New("webauthn: at least one origin must be set")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Minute
+	}
+	return &RelyingParty{config: config, rpHash: sha256.Sum256([]byte(config.RPID))}, nil
+}
+
+// Timeout is how long the user has to complete a ceremony.
+func (rp *RelyingParty) Timeout() time.Duration {
+	return rp.config.Timeout
+}
+
+// User identifies the account a credential is being registered for. ID is
+// the opaque user handle the authenticator stores; it must not contain
+// personal information.
+type User struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+// CredentialDescriptor refers to an existing credential in ceremony options.
+type CredentialDescriptor struct {
+	Type       string          `json:"type"`
+	ID         URLEncodedBytes `json:"id"`
+	Transports []string        `json:"transports,omitempty"`
+}
+
+// NewCredentialDescriptor describes a stored credential.
+func NewCredentialDescriptor(id []byte, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{Type: "public-key", ID: id, Transports: transports}
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type relyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create() to register a passkey.
+type CreationOptions struct {
+	Challenge              URLEncodedBytes        `json:"challenge"`
+	RP                     relyingPartyEntity     `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// CreationOptions builds registration options for user. exclude lists the
+// user's existing credentials so the same authenticator isn't registered twice.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]credentialParameter, len(SupportedAlgorithms))
+	for i, alg := range SupportedAlgorithms {
+		params[i] = credentialParameter{Type: "public-key", Alg: alg}
+	}
+	return CreationOptions{
+		Challenge:          challenge,
+		RP:                 relyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: append([]CredentialDescriptor{}, exclude...),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions are passed to navigator.credentials.get() to log in with a passkey.
+type RequestOptions struct {
+	Challenge        URLEncodedBytes        `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RequestOptions builds login options. With no allowed credentials the
+// authenticator offers any discoverable credential it has for this site.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: append([]CredentialDescriptor{}, allow...),
+		UserVerification: "required",
+	}
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned
+// by navigator.credentials.create().
+type RegistrationResponse struct {
+	ID       string          `json:"id"`
+	RawID    URLEncodedBytes `json:"rawId"`
+	Type     string          `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+		AttestationObject URLEncodedBytes `json:"attestationObject"`
+		Transports        []string        `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string          `json:"id"`
+	RawID    URLEncodedBytes `json:"rawId"`
+	Type     string          `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+		AuthenticatorData URLEncodedBytes `json:"authenticatorData"`
+		Signature         URLEncodedBytes `json:"signature"`
+		UserHandle        URLEncodedBytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a verified passkey to store against the user.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key encoding.
+	SignCount      uint32
+	Transports     []string
+	AAGUID         []byte
+	BackupEligible bool
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func parseClientData(raw []byte) (*clientData, []byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed client data", ErrInvalidResponse)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: malformed challenge", ErrInvalidResponse)
+	}
+	return &cd, challenge, nil
+}
+
+// ResponseChallenge returns the challenge the browser signed, so the server
+// can find the ceremony a response belongs to. It does not verify anything.
+func ResponseChallenge(clientDataJSON []byte) ([]byte, error) {
+	_, challenge, err := parseClientData(clientDataJSON)
+	return challenge, err
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, ceremony string, challenge []byte) error {
+	cd, signed, err := parseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrInvalidResponse, cd.Type)
+	}
+	if subtle.ConstantTimeCompare(signed, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+	if !slices.Contains(rp.config.Origins, cd.Origin) || cd.CrossOrigin {
+		return ErrOriginMismatch
+	}
+	return nil
+}
+
+// authenticatorData is the binary structure signed by the authenticator.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+	ad := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: readUint32(raw[33:37]),
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return ad, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+	}
+	ad.aaguid = rest[:16]
+	idLength := int(rest[16])<<8 | int(rest[17])
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, fmt.Errorf("%w: invalid credential ID", ErrInvalidResponse)
+	}
+	ad.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, n, err := parsePublicKey(rest)
+	if err != nil {
+		return nil, err
+	}
+	ad.publicKey = rest[:n]
+	return ad, nil
+}
+
+func (rp *RelyingParty) checkAuthenticatorData(ad *authenticatorData) error {
+	if subtle.ConstantTimeCompare(ad.rpIDHash, rp.rpHash[:]) != 1 {
+		return ErrRPIDMismatch
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+	// Passkey logins skip TOTP, so the authenticator must have checked the
+	// user's PIN or biometrics as well as their presence.
+	if ad.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+// VerifyRegistration checks a registration response against the challenge
+// issued for it and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *RegistrationResponse) (*Credential, error) {
+	err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	v, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrInvalidResponse)
+	}
+	attestation, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrInvalidResponse)
+	}
+	format, _ := cborString(attestation, "fmt")
+	if format != "none" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+	rawAuthData, ok := cborBytes(attestation, "authData")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing authenticator data", ErrInvalidResponse)
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("%w: no credential in authenticator data", ErrInvalidResponse)
+	}
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, ad.credentialID) {
+		return nil, fmt.Errorf("%w: credential ID does not match", ErrInvalidResponse)
+	}
+
+	return &Credential{
+		ID:             bytes.Clone(ad.credentialID),
+		PublicKey:      bytes.Clone(ad.publicKey),
+		SignCount:      ad.signCount,
+		Transports:     resp.Response.Transports,
+		AAGUID:         bytes.Clone(ad.aaguid),
+		BackupEligible: ad.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a login response for cred against the challenge
+// issued for it and returns the authenticator's new signature counter, which
+// should be saved with the credential.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, cred *Credential, resp *AssertionResponse) (uint32, error) {
+	if !bytes.Equal(resp.RawID, cred.ID) {
+		return 0, fmt.Errorf("%w: credential ID does not match", ErrInvalidResponse)
+	}
+	err := rp.checkClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.checkAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	key, _, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(bytes.Clone(resp.Response.AuthenticatorData), clientDataHash[:]...)
+	if !key.verify(signed, resp.Response.Signature) {
+		return 0, ErrBadSignature
+	}
+
+	// Authenticators that keep a counter must always increase it. Synced
+	// passkeys report zero, which is allowed.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return ad.signCount, nil
+}
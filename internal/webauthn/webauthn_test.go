@@ -0,0 +1,283 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// encodeCBOR is a minimal encoder for the types the software authenticator
+// needs. Map keys are sorted so the output is deterministic.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		var entries [][]byte
+		for k, val := range v {
+			entries = append(entries, append(encodeCBOR(k), encodeCBOR(val)...))
+		}
+		sort.Slice(entries, func(i, j int) bool { return string(entries[i]) < string(entries[j]) })
+		out := head(5, uint64(len(v)))
+		for _, e := range entries {
+			out = append(out, e...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+// softAuthenticator stands in for a security key or platform authenticator.
+type softAuthenticator struct {
+	t            *testing.T
+	rpID         string
+	origin       string
+	credentialID []byte
+	signer       crypto.Signer
+	coseKey      []byte
+	signCount    uint32
+	// skipVerification leaves out the user verified flag, as an
+	// authenticator with no PIN or biometrics set up would.
+	skipVerification bool
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	a := &softAuthenticator{t: t, rpID: testRPID, origin: testOrigin, credentialID: make([]byte, 16)}
+	_, err := rand.Read(a.credentialID)
+	assert.NoError(t, err)
+
+	switch alg {
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		point, err := key.PublicKey.Bytes()
+		assert.NoError(t, err)
+		a.signer = key
+		a.coseKey = encodeCBOR(map[any]any{
+			coseKty: ktyEC2, coseAlg: AlgES256, coseCrv: crvP256,
+			coseX: point[1:33], coseY: point[33:],
+		})
+	case AlgEdDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err)
+		a.signer = key
+		a.coseKey = encodeCBOR(map[any]any{
+			coseKty: ktyOKP, coseAlg: AlgEdDSA, coseCrv: crvEd25519, coseX: []byte(pub),
+		})
+	}
+	return a
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	cd, err := json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	assert.NoError(a.t, err)
+	return cd
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(flagUserPresent)
+	if !a.skipVerification {
+		flags |= flagUserVerified
+	}
+	if attested {
+		flags |= flagAttestedData
+	}
+	out := append(rpHash[:], flags)
+	out = binary.BigEndian.AppendUint32(out, a.signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...) // AAGUID
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.credentialID)))
+		out = append(out, a.credentialID...)
+		out = append(out, a.coseKey...)
+	}
+	return out
+}
+
+func (a *softAuthenticator) create(challenge []byte) *RegistrationResponse {
+	resp := &RegistrationResponse{RawID: a.credentialID, Type: "public-key"}
+	resp.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	resp.Response.AttestationObject = encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(true),
+	})
+	resp.Response.Transports = []string{"internal"}
+	return resp
+}
+
+func (a *softAuthenticator) get(challenge []byte) *AssertionResponse {
+	a.signCount++
+	resp := &AssertionResponse{RawID: a.credentialID, Type: "public-key"}
+	resp.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	resp.Response.ClientDataJSON = a.clientData("webauthn.get", challenge)
+	resp.Response.AuthenticatorData = a.authData(false)
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+
+	var err error
+	switch key := a.signer.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		resp.Response.Signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	case ed25519.PrivateKey:
+		resp.Response.Signature = ed25519.Sign(key, signed)
+	}
+	assert.NoError(a.t, err)
+	return resp
+}
+
+func newTestRelyingParty(t *testing.T) *RelyingParty {
+	rp, err := New(Config{RPID: testRPID, RPName: "Feel Flow", Origins: []string{testOrigin}})
+	assert.NoError(t, err)
+	return rp
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for name, alg := range map[string]int{"ES256": AlgES256, "EdDSA": AlgEdDSA} {
+		t.Run(name, func(t *testing.T) {
+			rp := newTestRelyingParty(t)
+			authenticator := newSoftAuthenticator(t, alg)
+
+			challenge, err := NewChallenge()
+			assert.NoError(t, err)
+			cred, err := rp.VerifyRegistration(challenge, authenticator.create(challenge))
+			assert.NoError(t, err)
+			assert.Equal(t, authenticator.credentialID, cred.ID)
+			assert.Equal(t, []string{"internal"}, cred.Transports)
+
+			challenge, err = NewChallenge()
+			assert.NoError(t, err)
+			resp := authenticator.get(challenge)
+			signCount, err := rp.VerifyAssertion(challenge, cred, resp)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(1), signCount)
+
+			got, err := ResponseChallenge(resp.Response.ClientDataJSON)
+			assert.NoError(t, err)
+			assert.Equal(t, challenge, got)
+
+			// Replaying the same assertion fails once the counter is saved.
+			cred.SignCount = signCount
+			_, err = rp.VerifyAssertion(challenge, cred, resp)
+			assert.ErrorIs(t, err, ErrSignCount)
+		})
+	}
+}
+
+func TestRegistrationRejectsWrongContext(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	challenge, err := NewChallenge()
+	assert.NoError(t, err)
+
+	other, err := NewChallenge()
+	assert.NoError(t, err)
+	_, err = rp.VerifyRegistration(other, newSoftAuthenticator(t, AlgES256).create(challenge))
+	assert.ErrorIs(t, err, ErrChallengeMismatch)
+
+	phished := newSoftAuthenticator(t, AlgES256)
+	phished.origin = "https://feel-fl0w.example"
+	_, err = rp.VerifyRegistration(challenge, phished.create(challenge))
+	assert.ErrorIs(t, err, ErrOriginMismatch)
+
+	wrongRP := newSoftAuthenticator(t, AlgES256)
+	wrongRP.rpID = "example.com"
+	_, err = rp.VerifyRegistration(challenge, wrongRP.create(challenge))
+	assert.ErrorIs(t, err, ErrRPIDMismatch)
+}
+
+func TestAssertionRejectsBadSignature(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftAuthenticator(t, AlgEdDSA)
+
+	challenge, err := NewChallenge()
+	assert.NoError(t, err)
+	cred, err := rp.VerifyRegistration(challenge, authenticator.create(challenge))
+	assert.NoError(t, err)
+
+	resp := authenticator.get(challenge)
+	resp.Response.Signature[0] ^= 0xff
+	_, err = rp.VerifyAssertion(challenge, cred, resp)
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	// A different authenticator can't sign for this credential.
+	impostor := newSoftAuthenticator(t, AlgEdDSA)
+	impostor.credentialID = authenticator.credentialID
+	_, err = rp.VerifyAssertion(challenge, cred, impostor.get(challenge))
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	for _, input := range [][]byte{
+		{},
+		{0x5f},                         // indefinite-length byte string
+		{0x43, 0x01},                   // truncated byte string
+		{0xa1, 0x01},                   // map missing its value
+		{0xa2, 0x01, 0x01, 0x01, 0x02}, // duplicate key
+		{0xfb, 0, 0, 0, 0, 0, 0, 0, 0}, // float64
+	} {
+		_, _, err := decodeCBOR(input)
+		assert.ErrorIs(t, err, errCBOR, "% x", input)
+	}
+}
+
+func TestUserVerificationRequired(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftAuthenticator(t, AlgES256)
+
+	challenge, err := NewChallenge()
+	assert.NoError(t, err)
+	cred, err := rp.VerifyRegistration(challenge, authenticator.create(challenge))
+	assert.NoError(t, err)
+
+	authenticator.skipVerification = true
+	_, err = rp.VerifyAssertion(challenge, cred, authenticator.get(challenge))
+	assert.ErrorIs(t, err, ErrUserNotVerified)
+
+	challenge, err = NewChallenge()
+	assert.NoError(t, err)
+	unverified := newSoftAuthenticator(t, AlgES256)
+	unverified.skipVerification = true
+	_, err = rp.VerifyRegistration(challenge, unverified.create(challenge))
+	assert.ErrorIs(t, err, ErrUserNotVerified)
+}
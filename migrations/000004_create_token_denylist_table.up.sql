@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS token_denylist (
+    jti text PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS token_denylist_expiry_idx ON token_denylist (expiry);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    credential_id bytea NOT NULL UNIQUE,
+    public_key bytea NOT NULL,
+    sign_count bigint NOT NULL DEFAULT 0,
+    transports text[] NOT NULL DEFAULT '{}',
+    aaguid bytea,
+    backup_eligible bool NOT NULL DEFAULT FALSE,
+    last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge_hash bytea PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    ceremony text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked bool NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS admin_actions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    admin_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    action text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS admin_actions_user_id_idx ON admin_actions (user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS security_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL,
+    actor_id bigint,
+    event text NOT NULL,
+    ip_address text NOT NULL,
+    user_agent text NOT NULL,
+    request_id text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS security_events_user_id_idx ON security_events (user_id, id);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    status text NOT NULL,
+    archive bytea,
+    expiry timestamp(0) with time zone NOT NULL
+);
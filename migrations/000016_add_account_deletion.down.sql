@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+DROP INDEX IF EXISTS moods_user_id_idx;
+ALTER TABLE moods DROP CONSTRAINT IF EXISTS moods_user_id_fkey;
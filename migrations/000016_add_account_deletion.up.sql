@@ -0,0 +1,8 @@
+-- Moods were never tied to their owner, so accounts deleted before now left
+-- theirs behind. Remove them before adding the constraint.
+DELETE FROM moods WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = moods.user_id);
+
+ALTER TABLE moods ADD CONSTRAINT moods_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS moods_user_id_idx ON moods (user_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at timestamp(0) with time zone;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    timezone text NOT NULL DEFAULT 'UTC',
+    locale text NOT NULL DEFAULT 'en',
+    week_start text NOT NULL DEFAULT 'monday',
+    units text NOT NULL DEFAULT 'metric',
+    mood_palette text[] NOT NULL,
+    reminder_time time,
+    version integer NOT NULL DEFAULT 1
+);
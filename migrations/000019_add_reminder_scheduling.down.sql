@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS user_settings_reminder_time_idx;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS reminder_sent_on;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS reminders_snoozed_until;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS reminders_paused;
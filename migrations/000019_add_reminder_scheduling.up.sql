@@ -0,0 +1,6 @@
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS reminders_paused bool NOT NULL DEFAULT false;
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS reminders_snoozed_until timestamp(0) with time zone;
+-- The local date of the last reminder sent, so each user gets at most one a
+-- day however many API instances are running.
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS reminder_sent_on date;
+CREATE INDEX IF NOT EXISTS user_settings_reminder_time_idx ON user_settings (reminder_time) WHERE reminder_time IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS digest_deliveries;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS digest_day;
+ALTER TABLE user_settings DROP COLUMN IF EXISTS weekly_digest;
@@ -0,0 +1,13 @@
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS weekly_digest bool NOT NULL DEFAULT false;
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS digest_day text NOT NULL DEFAULT 'sunday';
+
+-- One row per digest, keyed by the first day of the user's week (under their
+-- week_start) it was sent in, so nobody is sent two digests in a week however
+-- many API instances are running, even if they change their digest day.
+CREATE TABLE IF NOT EXISTS digest_deliveries (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    week date NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    sent_at timestamp(0) with time zone,
+    PRIMARY KEY (user_id, week)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS email_messages (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL,
+    recipient citext NOT NULL,
+    template text NOT NULL,
+    locale text NOT NULL,
+    subject text NOT NULL,
+    data jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS email_messages_recipient_idx ON email_messages (recipient, template, created_at);
+CREATE INDEX IF NOT EXISTS email_messages_created_at_idx ON email_messages (created_at);
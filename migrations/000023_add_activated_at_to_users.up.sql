@@ -0,0 +1,5 @@
+-- Records when an account was first activated, so accounts an admin later
+-- deactivates are never reminded to activate or purged as unactivated.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated_at timestamp(0) with time zone;
+
+UPDATE users SET activated_at = created_at, activation_reminder_sent_at = NULL WHERE activated = true;
@@ -0,0 +1 @@
+ALTER TABLE token_denylist ALTER COLUMN expiry TYPE timestamp(0) with time zone;
@@ -0,0 +1,3 @@
+-- Revocation times are compared with the millisecond a token was issued, so a
+-- login in the same second as a logout-all keeps its new token.
+ALTER TABLE token_denylist ALTER COLUMN expiry TYPE timestamp(3) with time zone;
@@ -0,0 +1 @@
+-- The tokens removed from the job payloads can't be restored.
@@ -0,0 +1,3 @@
+-- Email jobs used to carry the tokens their emails link to. The worker now
+-- issues them when it sends the email, so remove any left in the queue.
+UPDATE jobs SET payload = payload - 'secrets' WHERE kind = 'send_email';